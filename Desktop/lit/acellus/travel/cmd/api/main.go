@@ -6,65 +6,479 @@ Handles user authentication and journal entries with photo uploads
 package main
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // the alpine image has no zoneinfo, embed it so time zones always load
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/karadeskin/travel/internal/diff"
+	"github.com/karadeskin/travel/internal/domain"
+	"github.com/karadeskin/travel/internal/events"
+	"github.com/karadeskin/travel/internal/geo"
+	"github.com/karadeskin/travel/internal/imaging"
+	"github.com/karadeskin/travel/internal/mail"
+	"github.com/lib/pq"
+	"github.com/skip2/go-qrcode"
 )
 
 // Entry struct represents a journal entry
 type Entry struct {
+	ID       int    `json:"id" db:"id"`
+	UserID   int    `json:"user_id" db:"user_id"`
+	TripID   *int   `json:"trip_id" db:"trip_id"`
+	Title    string `json:"title" db:"title"`
+	Content  string `json:"content" db:"content"`
+	Location string `json:"location" db:"location"`
+	// CountryCode is the ISO 3166 code resolved from the coordinates
+	CountryCode string `json:"country_code" db:"country_code"`
+	// Latitude and Longitude are optional, both are set or neither is
+	Latitude  *float64 `json:"latitude" db:"latitude"`
+	Longitude *float64 `json:"longitude" db:"longitude"`
+	// DistanceKM is only filled in by nearby searches
+	DistanceKM *float64 `json:"distance_km,omitempty"`
+	Photos     []string `json:"photos"`
+	Tags       []string `json:"tags"`
+	// Status is "draft" or "published", drafts only show up for their author
+	Status string `json:"status" db:"status"`
+	// Visibility is "private", "link" (readable through share links) or "public"
+	Visibility string `json:"visibility" db:"visibility"`
+	// Version goes up by one on every edit, send it back as If-Match to avoid overwriting a newer save
+	Version   int       `json:"version" db:"version"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Author is who wrote the entry, which matters on shared trips
+	Author       *Author `json:"author"`
+	CommentCount int     `json:"comment_count"`
+	// Reactions counts each reaction name the entry has received, FavoriteCount how many people saved it
+	Reactions     map[string]int `json:"reactions"`
+	FavoriteCount int            `json:"favorite_count"`
+	// DeletedAt is set while the entry sits in the trash, it is purged trashRetention later
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// PhotoDetails carries the size and blurhash for each photo that has them
+	PhotoDetails []Photo `json:"photo_details"`
+	// OccurredAt is when the moment happened (UTC), OccurredAtLocal is the same instant in TimeZone
+	OccurredAt      time.Time `json:"occurred_at" db:"occurred_at"`
+	OccurredAtLocal string    `json:"occurred_at_local"`
+	TimeZone        string    `json:"time_zone" db:"time_zone"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+// Author struct is the public face of a user shown next to their entries
+type Author struct {
+	ID       int    `json:"id" db:"id"`
+	Username string `json:"username" db:"username"`
+}
+
+// Photo struct holds the metadata computed when a photo is uploaded
+type Photo struct {
+	URL      string `json:"url" db:"url"`
+	Width    int    `json:"width" db:"width"`
+	Height   int    `json:"height" db:"height"`
+	BlurHash string `json:"blurhash" db:"blurhash"`
+}
+
+// Trip struct groups a user's entries from one journey
+type Trip struct {
+	ID          int    `json:"id" db:"id"`
+	UserID      int    `json:"user_id" db:"user_id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	// Role is the caller's role on the trip: owner, editor or viewer
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TripMember struct is someone invited to a trip by its owner
+type TripMember struct {
+	TripID      int        `json:"trip_id" db:"trip_id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Username    string     `json:"username" db:"username"`
+	Role        string     `json:"role" db:"role"`
+	Status      string     `json:"status" db:"status"` // pending, accepted or declined
+	InvitedBy   int        `json:"invited_by" db:"invited_by"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RespondedAt *time.Time `json:"responded_at" db:"responded_at"`
+}
+
+// Comment struct is a comment on an entry, Replies holds the comments answering it
+type Comment struct {
+	ID        int        `json:"id" db:"id"`
+	EntryID   int        `json:"entry_id" db:"entry_id"`
+	ParentID  *int       `json:"parent_id" db:"parent_id"`
+	Author    Author     `json:"author"`
+	Body      string     `json:"body" db:"body"`
+	Deleted   bool       `json:"deleted"`
+	EditedAt  *time.Time `json:"edited_at" db:"edited_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	Replies   []*Comment `json:"replies"`
+}
+
+type CommentRequest struct {
+	Body     string `json:"body"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// Notification struct tells a user that someone did something involving them,
+// the IDs that apply to its type are set and the rest are null
+type Notification struct {
 	ID        int       `json:"id" db:"id"`
-	UserID    int       `json:"user_id" db:"user_id"`
-	Title     string    `json:"title" db:"title"`
-	Content   string    `json:"content" db:"content"`
-	Location  string    `json:"location" db:"location"`
-	Photos    []string  `json:"photos"`
+	Type      string    `json:"type" db:"type"`
+	Actor     *Author   `json:"actor"`
+	EntryID   *int      `json:"entry_id" db:"entry_id"`
+	CommentID *int      `json:"comment_id" db:"comment_id"`
+	TripID    *int      `json:"trip_id" db:"trip_id"`
+	Read      bool      `json:"read"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
-type RegisterRequest struct {
+// notice is a notification about to be sent, see notify
+type notice struct {
+	userID    int // who gets it
+	actorID   int // who caused it
+	kind      string
+	entryID   *int
+	commentID *int
+	tripID    *int
+}
+
+// Follow struct is one side of the follow graph, used for both followers and following lists
+type Follow struct {
+	User      Author    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation struct is a pending trip invitation as seen by the invitee
+type Invitation struct {
+	TripID    int       `json:"trip_id"`
+	TripName  string    `json:"trip_name"`
+	InvitedBy Author    `json:"invited_by"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MemberRequest invites a user to a trip by username or email, or changes an existing member's role
+type MemberRequest struct {
+	User string `json:"user" binding:"required,max=255"` // username or email
+	Role string `json:"role" binding:"required"`
+}
+
+// Track struct is a GPS track imported from a GPX file and attached to a trip
+type Track struct {
+	ID              int            `json:"id" db:"id"`
+	TripID          int            `json:"trip_id" db:"trip_id"`
+	Name            string         `json:"name" db:"name"`
+	DistanceKM      float64        `json:"distance_km" db:"distance_km"`
+	ElevationGainM  float64        `json:"elevation_gain_m" db:"elevation_gain_m"`
+	DurationSeconds int64          `json:"duration_seconds" db:"duration_seconds"`
+	StartedAt       *time.Time     `json:"started_at" db:"started_at"`
+	EndedAt         *time.Time     `json:"ended_at" db:"ended_at"`
+	Polylines       []string       `json:"polylines" db:"polylines"` // one Google encoded polyline per track segment
+	Waypoints       []geo.Waypoint `json:"waypoints" db:"waypoints"`
+	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
+}
+
+// Stats struct is the year-in-review style summary returned by /me/stats
+type Stats struct {
+	TotalEntries      int          `json:"total_entries"`
+	TotalPhotos       int          `json:"total_photos"`
+	EntriesPerMonth   []MonthCount `json:"entries_per_month"`
+	CountriesVisited  int          `json:"countries_visited"`
+	Countries         []NamedCount `json:"countries"`
+	CitiesVisited     int          `json:"cities_visited"`
+	Cities            []NamedCount `json:"cities"`
+	LongestTrip       *TripSpan    `json:"longest_trip"`
+	CurrentStreakDays int          `json:"current_streak_days"`
+	LongestStreakDays int          `json:"longest_streak_days"`
+	DistanceKM        float64      `json:"distance_km"`       // straight lines between consecutive located entries
+	TrackDistanceKM   float64      `json:"track_distance_km"` // sum of imported GPX tracks
+}
+
+type MonthCount struct {
+	Month string `json:"month"` // YYYY-MM
+	Count int    `json:"count"`
+}
+
+type NamedCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TripSpan struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Days      int       `json:"days"`
+}
+
+type TripRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Profile struct is the logged in user's own account settings
+type Profile struct {
+	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	// EmailVerified is false until the link sent at registration has been opened
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	TimeZone         string `json:"time_zone"` // IANA name, used to decide which calendar day an entry falls on
+}
+
+type ProfileRequest struct {
+	TimeZone *string `json:"time_zone"`
+}
+
+// MemoryYear groups the entries written on the same calendar day in one earlier year
+type MemoryYear struct {
+	Year     int     `json:"year"`
+	YearsAgo int     `json:"years_ago"`
+	Entries  []Entry `json:"entries"`
+}
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required,max=255"`
+	Email    string `json:"email" binding:"required,email,max=255"`
+	Password string `json:"password" binding:"required,min=8"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginCodeRequest finishes a login for an account with two-factor authentication,
+// Code is either from the authenticator app or one of the recovery codes
+type LoginCodeRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+type TwoFactorRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest turns two-factor authentication off, which needs the password as well as a code
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
 }
 
 type EntryRequest struct {
-	UserID   string   `json:"user_id"`
 	Title    string   `json:"title"`
 	Content  string   `json:"content"`
 	Location string   `json:"location"`
 	Photos   []string `json:"photos"`
+	TripID   *int     `json:"trip_id"`
+	// Latitude and Longitude must be sent together
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	// OccurredAt is RFC3339, or a local "2006-01-02T15:04" read in TimeZone; defaults to now
+	OccurredAt string `json:"occurred_at"`
+	// TimeZone is an IANA name, defaults to the author's profile setting
+	TimeZone string   `json:"time_zone"`
+	Tags     []string `json:"tags"`
+	// Status is "draft" or "published" (the default)
+	Status string `json:"status"`
+	// Visibility is "private" (the default), "link" or "public"
+	Visibility string `json:"visibility"`
+}
+
+// EntryPatch holds the fields of an entry edit, nil fields are left unchanged.
+// The Nullable ones can also be cleared by sending null
+type EntryPatch struct {
+	Title      *string           `json:"title"`
+	Content    *string           `json:"content"`
+	Location   *string           `json:"location"`
+	Photos     *[]string         `json:"photos"`
+	TripID     Nullable[int]     `json:"trip_id"`
+	Latitude   Nullable[float64] `json:"latitude"`
+	Longitude  Nullable[float64] `json:"longitude"`
+	OccurredAt *string           `json:"occurred_at"`
+	TimeZone   *string           `json:"time_zone"`
+	Tags       *[]string         `json:"tags"`
+	Status     *string           `json:"status"`
+	Visibility *string           `json:"visibility"`
+}
+
+// Nullable is a patch field that tells a field left out (Set is false) from an explicit null (Set with a nil Value)
+type Nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	n.Value = new(T)
+	return json.Unmarshal(data, n.Value)
+}
+
+// SharedEntry is the read-only view of an entry served through a share link,
+// it leaves out anything that points back at the author's other data
+type SharedEntry struct {
+	Title           string    `json:"title"`
+	Content         string    `json:"content"`
+	Location        string    `json:"location"`
+	CountryCode     string    `json:"country_code"`
+	Latitude        *float64  `json:"latitude"`
+	Longitude       *float64  `json:"longitude"`
+	Photos          []string  `json:"photos"`
+	PhotoDetails    []Photo   `json:"photo_details"`
+	Tags            []string  `json:"tags"`
+	OccurredAt      time.Time `json:"occurred_at"`
+	OccurredAtLocal string    `json:"occurred_at_local"`
+	TimeZone        string    `json:"time_zone"`
+}
+
+// ShareLink struct is a share link as shown to the entry's author, the token itself is only returned once
+type ShareLink struct {
+	ID        int        `json:"id" db:"id"`
+	EntryID   int        `json:"entry_id" db:"entry_id"`
+	Token     string     `json:"token,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ShareRequest is the body of POST /entry/:id/shares, without an expiry the link lasts until revoked
+type ShareRequest struct {
+	ExpiresInHours int `json:"expires_in_hours"`
+}
+
+// Revision struct is a snapshot of an entry taken after every change
+type Revision struct {
+	ID          int       `json:"id" db:"id"`
+	EntryID     int       `json:"entry_id" db:"entry_id"`
+	Rev         int       `json:"rev" db:"rev"` // the entry version this snapshot is of
+	AuthorID    int       `json:"author_id" db:"author_id"`
+	Title       string    `json:"title" db:"title"`
+	Content     string    `json:"content" db:"content"`
+	Location    string    `json:"location" db:"location"`
+	CountryCode string    `json:"country_code" db:"country_code"`
+	Latitude    *float64  `json:"latitude" db:"latitude"`
+	Longitude   *float64  `json:"longitude" db:"longitude"`
+	Photos      []string  `json:"photos" db:"photos"`
+	Tags        []string  `json:"tags" db:"tags"`
+	TripID      *int      `json:"trip_id" db:"trip_id"`
+	OccurredAt  time.Time `json:"occurred_at" db:"occurred_at"`
+	TimeZone    string    `json:"time_zone" db:"time_zone"`
+	Status      *string   `json:"status" db:"status"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+)
+
 var db *sql.DB
 
+// hub carries live updates to open /events streams, on this replica and the others
+var hub *events.Hub
+
+// mailer sends account email, appSecret signs the links in it and publicURL is where they point,
+// appURL is the frontend, for links that need a page of their own
+var (
+	mailer    mail.Mailer
+	appSecret []byte
+	publicURL string
+	appURL    string
+)
+
+// geocoder resolves coordinates to the nearest known place without any network calls
+var geocoder *geo.Geocoder
+
+// largest photo accepted, about what a 50 megapixel camera produces
+const maxPhotoPixels = 50_000_000
+
+// how far from a known place coordinates can be and still be labelled with it, and how far
+// they can be and still take its country (coastlines and remote areas are far from any city)
+const (
+	geocodeCityKM    = 25
+	geocodeCountryKM = 150
+)
+
+// how long sending one email may take
+const mailTimeout = 30 * time.Second
+
+// how soon another verification or password reset email can be asked for
+const resendInterval = time.Minute
+
+// how often an idle /events stream gets a keep-alive comment
+const eventHeartbeat = 25 * time.Second
+
+// page sizes for GET /me/notifications
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// A notification the same as one sent this recently isn't sent again, so toggling a
+// reaction or following and unfollowing doesn't flood the recipient's inbox
+const notificationDedupeWindow = time.Hour
+
+// page sizes for GET /feed
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// how long deleted entries stay in the trash before they are gone for good
+const trashRetention = 30 * 24 * time.Hour
+
+// failed logins are counted per account (the email tried) and per client address
+var loginLimits = []struct {
+	scope string
+	limit domain.LoginLimit
+}{
+	{"account", domain.AccountLoginLimit},
+	{"ip", domain.IPLoginLimit},
+}
+
+// a hash to check passwords against when the email has no account, so the response takes as long either way
+var dummyPasswordHash string
+
 func initDB() {
 	var err error
-	
-	// Get database URL from environment (Railway provides this)
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		// Fallback for local development
-		dbURL = "postgres://localhost/travel?sslmode=disable"
-	}
 
-	db, err = sql.Open("postgres", dbURL)
+	db, err = sql.Open("postgres", databaseURL())
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -80,6 +494,15 @@ func initDB() {
 	createTables()
 }
 
+// Get database URL from environment (Railway provides this)
+func databaseURL() string {
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		return dbURL
+	}
+	// Fallback for local development
+	return "postgres://localhost/travel?sslmode=disable"
+}
+
 func createTables() {
 	// Create users table
 	userTable := `
@@ -88,7 +511,7 @@ func createTables() {
 		username VARCHAR(255) UNIQUE NOT NULL,
 		email VARCHAR(255) UNIQUE NOT NULL,
 		password_hash VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 	)`
 
 	// Create entries table
@@ -100,7 +523,225 @@ func createTables() {
 		content TEXT NOT NULL,
 		location VARCHAR(255),
 		photos TEXT[], -- PostgreSQL array for photos
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create sessions table (only the token hash is stored)
+	sessionTable := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash CHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`
+
+	// Create trips table
+	tripTable := `
+	CREATE TABLE IF NOT EXISTS trips (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create tracks table (simplified GPX routes attached to trips)
+	trackTable := `
+	CREATE TABLE IF NOT EXISTS tracks (
+		id SERIAL PRIMARY KEY,
+		trip_id INTEGER NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		distance_km DOUBLE PRECISION NOT NULL,
+		elevation_gain_m DOUBLE PRECISION NOT NULL,
+		duration_seconds BIGINT NOT NULL,
+		started_at TIMESTAMPTZ,
+		ended_at TIMESTAMPTZ,
+		polylines TEXT[] NOT NULL,
+		waypoints JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create tags tables (tag names are stored normalized to lowercase)
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(50) UNIQUE NOT NULL
+	)`
+
+	entryTagTable := `
+	CREATE TABLE IF NOT EXISTS entry_tags (
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (entry_id, tag_id)
+	)`
+
+	// Create entry_revisions table (append-only history of every entry change)
+	revisionTable := `
+	CREATE TABLE IF NOT EXISTS entry_revisions (
+		id SERIAL PRIMARY KEY,
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		rev INTEGER NOT NULL,
+		author_id INTEGER REFERENCES users(id),
+		title VARCHAR(255) NOT NULL,
+		content TEXT NOT NULL,
+		location VARCHAR(255),
+		country_code CHAR(2),
+		latitude DOUBLE PRECISION,
+		longitude DOUBLE PRECISION,
+		photos TEXT[],
+		tags TEXT[] NOT NULL DEFAULT '{}',
+		trip_id INTEGER,
+		occurred_at TIMESTAMPTZ NOT NULL,
+		time_zone VARCHAR(64) NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (entry_id, rev)
+	)`
+
+	// Create share_links table (tokens that grant read access to a single entry)
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id SERIAL PRIMARY KEY,
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		expires_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create trip_members table (users invited to someone else's trip)
+	tripMemberTable := `
+	CREATE TABLE IF NOT EXISTS trip_members (
+		trip_id INTEGER NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role VARCHAR(16) NOT NULL CHECK (role IN ('editor', 'viewer')),
+		status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+		invited_by INTEGER NOT NULL REFERENCES users(id),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		responded_at TIMESTAMPTZ,
+		PRIMARY KEY (trip_id, user_id)
+	)`
+
+	// Create comments table (threaded through parent_id, deleted comments keep their place)
+	commentTable := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id SERIAL PRIMARY KEY,
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+		body TEXT NOT NULL,
+		edited_at TIMESTAMPTZ,
+		deleted_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create reactions table (one row per user, entry and reaction)
+	reactionTable := `
+	CREATE TABLE IF NOT EXISTS reactions (
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		reaction VARCHAR(16) NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (entry_id, user_id, reaction)
+	)`
+
+	// Create favorites table (entries a user has bookmarked)
+	favoriteTable := `
+	CREATE TABLE IF NOT EXISTS favorites (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, entry_id)
+	)`
+
+	// Create notifications table (newest first per user, read_at is set once seen)
+	notificationTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		actor_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+		type VARCHAR(32) NOT NULL,
+		entry_id INTEGER REFERENCES entries(id) ON DELETE CASCADE,
+		comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+		trip_id INTEGER REFERENCES trips(id) ON DELETE CASCADE,
+		read_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	// Create notification_preferences table (only types a user has changed get a row, the default is on)
+	notificationPrefTable := `
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type VARCHAR(32) NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (user_id, type)
+	)`
+
+	// Create password_resets table (single use tokens, only the sha256 is kept)
+	passwordResetTable := `
+	CREATE TABLE IF NOT EXISTS password_resets (
+		token_hash CHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ
+	)`
+
+	// Create recovery_codes table (one-time codes for when the authenticator app is lost, only the sha256 is kept)
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash CHAR(64) NOT NULL,
+		used_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, code_hash)
+	)`
+
+	// Create login_challenges table (a password login waiting for its second factor)
+	loginChallengeTable := `
+	CREATE TABLE IF NOT EXISTS login_challenges (
+		token_hash CHAR(64) PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`
+
+	// Create login_failures table (recent failed logins per account and per address, for backoff and lockout)
+	loginFailureTable := `
+	CREATE TABLE IF NOT EXISTS login_failures (
+		scope VARCHAR(16) NOT NULL,
+		key VARCHAR(255) NOT NULL,
+		failures INTEGER NOT NULL,
+		last_failure_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (scope, key)
+	)`
+
+	// Create stream_tickets table (single use tickets that open /events for a session without the Authorization header)
+	streamTicketTable := `
+	CREATE TABLE IF NOT EXISTS stream_tickets (
+		token_hash CHAR(64) PRIMARY KEY,
+		session_hash CHAR(64) NOT NULL REFERENCES sessions(token_hash) ON DELETE CASCADE,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`
+
+	// Create follows table (who follows whom, the feed reads it at request time)
+	followTable := `
+	CREATE TABLE IF NOT EXISTS follows (
+		follower_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		followee_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (follower_id, followee_id),
+		CHECK (follower_id <> followee_id)
+	)`
+
+	// Create photos table (metadata for uploaded files, keyed by URL)
+	photoTable := `
+	CREATE TABLE IF NOT EXISTS photos (
+		url VARCHAR(512) PRIMARY KEY,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		blurhash VARCHAR(64) NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 	)`
 
 	if _, err := db.Exec(userTable); err != nil {
@@ -111,326 +752,4792 @@ func createTables() {
 		log.Fatalf("Failed to create entries table: %v", err)
 	}
 
-	log.Println("Database tables created successfully")
-}
+	if _, err := db.Exec(photoTable); err != nil {
+		log.Fatalf("Failed to create photos table: %v", err)
+	}
 
-// Hash password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+	if _, err := db.Exec(sessionTable); err != nil {
+		log.Fatalf("Failed to create sessions table: %v", err)
+	}
 
-// Check password hash
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
+	if _, err := db.Exec(tripTable); err != nil {
+		log.Fatalf("Failed to create trips table: %v", err)
+	}
 
-func main() {
-	// Initialize database
-	initDB()
-	defer db.Close()
+	if _, err := db.Exec(trackTable); err != nil {
+		log.Fatalf("Failed to create tracks table: %v", err)
+	}
 
-	// Initialize Gin router
-	r := gin.Default()
+	if _, err := db.Exec(tagTable); err != nil {
+		log.Fatalf("Failed to create tags table: %v", err)
+	}
 
-	// CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+	if _, err := db.Exec(entryTagTable); err != nil {
+		log.Fatalf("Failed to create entry_tags table: %v", err)
+	}
+
+	// Bring tables created by older versions up to date
+	legacyZone := legacyTimeZone()
+	migrations := []string{
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS trip_id INTEGER REFERENCES trips(id)`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_trip_id ON entries(trip_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90)`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180)`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_user_coords ON entries(user_id, latitude, longitude) WHERE latitude IS NOT NULL`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS country_code CHAR(2)`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_user_country ON entries(user_id, country_code)`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS place VARCHAR(255)`,
+		// When the coordinates were last looked up, so the backfill tries each entry once
+		// even when nothing is near enough to name
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS geocoded_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS idx_tracks_trip_id ON tracks(trip_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC'`,
+		timestamptzMigration("users", "created_at", legacyZone),
+		timestamptzMigration("entries", "created_at", legacyZone),
+		timestamptzMigration("sessions", "created_at", legacyZone),
+		timestamptzMigration("sessions", "expires_at", legacyZone),
+		timestamptzMigration("trips", "created_at", legacyZone),
+		timestamptzMigration("tracks", "started_at", legacyZone),
+		timestamptzMigration("tracks", "ended_at", legacyZone),
+		timestamptzMigration("tracks", "created_at", legacyZone),
+		timestamptzMigration("photos", "created_at", legacyZone),
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS occurred_at TIMESTAMPTZ`,
+		`UPDATE entries SET occurred_at = created_at WHERE occurred_at IS NULL`,
+		`ALTER TABLE entries ALTER COLUMN occurred_at SET DEFAULT NOW(), ALTER COLUMN occurred_at SET NOT NULL`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64)`,
+		`UPDATE entries e SET time_zone = u.time_zone FROM users u WHERE e.user_id = u.id AND e.time_zone IS NULL`,
+		`UPDATE entries SET time_zone = 'UTC' WHERE time_zone IS NULL`,
+		`ALTER TABLE entries ALTER COLUMN time_zone SET DEFAULT 'UTC', ALTER COLUMN time_zone SET NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_user_occurred ON entries(user_id, occurred_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_entry_tags_tag_id ON entry_tags(tag_id)`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'published'))`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ`,
+		`UPDATE entries SET updated_at = created_at WHERE updated_at IS NULL`,
+		`ALTER TABLE entries ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_user_drafts ON entries(user_id, updated_at DESC) WHERE status = 'draft'`,
+		revisionTable,
+		// Revisions from before this only have the content, restoring one leaves the status alone
+		`ALTER TABLE entry_revisions ADD COLUMN IF NOT EXISTS status VARCHAR(16)`,
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS idx_entries_deleted_at ON entries(deleted_at) WHERE deleted_at IS NOT NULL`,
+		// Entries from before visibility existed were listed for everyone, so they stay public,
+		// new ones are private until their author shares them
+		`ALTER TABLE entries ADD COLUMN IF NOT EXISTS visibility VARCHAR(16) NOT NULL DEFAULT 'public' CHECK (visibility IN ('private', 'link', 'public'))`,
+		`ALTER TABLE entries ALTER COLUMN visibility SET DEFAULT 'private'`,
+		shareLinkTable,
+		`CREATE INDEX IF NOT EXISTS idx_share_links_entry_id ON share_links(entry_id)`,
+		tripMemberTable,
+		`CREATE INDEX IF NOT EXISTS idx_trip_members_user_id ON trip_members(user_id, status)`,
+		followTable,
+		commentTable,
+		`CREATE INDEX IF NOT EXISTS idx_comments_entry_id ON comments(entry_id, created_at)`,
+		reactionTable,
+		favoriteTable,
+		// Reactions are counted per entry through their primary key, favorites need their own index for it
+		`CREATE INDEX IF NOT EXISTS idx_favorites_entry_id ON favorites(entry_id)`,
+		notificationTable,
+		notificationPrefTable,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL`,
+		// Accounts from before verification existed count as verified, new ones start out pending
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ DEFAULT NOW()`,
+		`ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_sent_at TIMESTAMPTZ`,
+		// Older accounts may have mixed case addresses, lookups go through LOWER(email) and it has to be
+		// unique. Accounts whose addresses only differ by case can't be merged or renamed automatically,
+		// startup stops and lists them until someone has sorted them out
+		`DO $$
+		DECLARE
+			duplicates TEXT;
+		BEGIN
+			SELECT string_agg(format('%s (user ids %s)', email, ids), '; ') INTO duplicates
+			FROM (
+				SELECT LOWER(email) AS email, string_agg(id::text, ', ' ORDER BY id) AS ids
+				FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1
+			) d;
+			IF duplicates IS NOT NULL THEN
+				RAISE EXCEPTION 'accounts share an email address ignoring case, change or remove all but one of each before upgrading: %', duplicates;
+			END IF;
+		END $$`,
+		`DROP INDEX IF EXISTS idx_users_email_lower`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_unique ON users(LOWER(email))`,
+		passwordResetTable,
+		`CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_follows_followee_id ON follows(followee_id)`,
+		// totp_secret is set at setup and only used for logins once totp_enabled_at is set,
+		// totp_last_step is the last code accepted so it can't be used twice
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT`,
+		recoveryCodeTable,
+		loginChallengeTable,
+		`CREATE INDEX IF NOT EXISTS idx_login_challenges_user_id ON login_challenges(user_id)`,
+		loginFailureTable,
+		streamTicketTable,
+		// The feed walks each followed user's public entries newest first
+		`CREATE INDEX IF NOT EXISTS idx_entries_public_feed ON entries(user_id, created_at DESC, id DESC)
+		WHERE status = 'published' AND visibility = 'public' AND deleted_at IS NULL`,
+		// Entries written before history existed get their current state as the first revision
+		revisionInsert + `
+		SELECT e.id, e.version, e.user_id, ` + revisionSnapshot + ` FROM entries e
+		WHERE NOT EXISTS (SELECT 1 FROM entry_revisions r WHERE r.entry_id = e.id)`,
+	}
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
 		}
-		c.Next()
-	})
+	}
 
-	// Serve static files (uploaded photos)
-	r.Static("/uploads", "./public/uploads")
+	log.Println("Database tables created successfully")
+}
 
-	// Health check endpoint
-	r.GET("/healthz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+// Build dummyPasswordHash at startup so the first unknown email isn't slower than the rest. While
+// accounts still have bcrypt hashes from before argon2id, which are slower to check, it uses bcrypt too
+func initDummyPasswordHash() {
+	hashers := []domain.Hasher{domain.DefaultHasher}
+	var legacy bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE password_hash LIKE '$2%')`).Scan(&legacy); err != nil {
+		log.Fatalf("Failed to check password hashes: %v", err)
+	}
+	if legacy {
+		hashers = append(hashers, domain.LegacyBcrypt)
+	}
 
-	// Photo upload endpoint
-	r.POST("/upload", func(c *gin.Context) {
-		file, header, err := c.Request.FormFile("photo")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
-			return
-		}
-		defer file.Close()
+	var err error
+	if dummyPasswordHash, err = domain.DummyHash(hashers...); err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+}
 
-		// Validate file type
-		filename := header.Filename
-		ext := strings.ToLower(filepath.Ext(filename))
-		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Only JPG, PNG, and GIF files are allowed"})
-			return
+// Older databases stored naive TIMESTAMP columns holding the server's wall clock time,
+// convert one to TIMESTAMPTZ reading it in zone (only once)
+func timestamptzMigration(table, column, zone string) string {
+	return fmt.Sprintf(`
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = '%[1]s' AND column_name = '%[2]s' AND data_type = 'timestamp without time zone'
+		) THEN
+			ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE TIMESTAMPTZ USING %[2]s AT TIME ZONE %[3]s;
+		END IF;
+	END $$`, table, column, pq.QuoteLiteral(zone))
+}
+
+// The zone naive timestamps were written in: LEGACY_TIME_ZONE if set, otherwise the
+// server's own zone from TZ or /etc/localtime, and UTC when neither names one
+func legacyTimeZone() string {
+	zone := os.Getenv("LEGACY_TIME_ZONE")
+	if zone == "" {
+		zone = strings.TrimPrefix(os.Getenv("TZ"), ":")
+	}
+	if zone == "" {
+		if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+			_, zone, _ = strings.Cut(target, "zoneinfo/")
 		}
+	}
+	if zone == "" {
+		return "UTC"
+	}
+	if _, err := time.LoadLocation(zone); err != nil {
+		log.Fatalf("Invalid legacy time zone %q: %v", zone, err)
+	}
+	return zone
+}
 
-		// Create unique filename
-		timestamp := time.Now().Unix()
-		newFilename := fmt.Sprintf("%d_%s", timestamp, filename)
-		filepath := fmt.Sprintf("./public/uploads/%s", newFilename)
+// Emails are compared case-insensitively, so they're stored lower case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
-		// Create uploads directory if it doesn't exist
-		os.MkdirAll("./public/uploads", 0755)
+// Create a session for a user and return the token to hand to the client
+func createSession(userID int) (string, error) {
+	token, hash, err := domain.NewSessionToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hash, userID, time.Now().Add(domain.SessionTTL))
+	return token, err
+}
 
-		// Save file
-		out, err := os.Create(filepath)
+// Reserve a login attempt for email from ip before any password or code is checked. The attempt
+// is counted as a failure straight away while the rows are locked, so parallel requests can't all get
+// in before the count goes up, and releaseLoginAttempt gives it back if it turns out fine.
+// Returns how long to wait instead when the client is backed off, and the account's count with this attempt
+func reserveLoginAttempt(email, ip string) (time.Duration, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	keys := map[string]string{"account": email, "ip": ip}
+	counts := map[string]int{}
+	var wait time.Duration
+	now := time.Now()
+	for _, l := range loginLimits {
+		// The row has to exist to be locked, the first request for a key creates it
+		_, err := tx.Exec(`INSERT INTO login_failures (scope, key, failures, last_failure_at) VALUES ($1, $2, 0, NOW())
+		ON CONFLICT (scope, key) DO NOTHING`, l.scope, keys[l.scope])
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-			return
+			return 0, 0, err
 		}
-		defer out.Close()
-
-		_, err = io.Copy(out, file)
+		var failures int
+		var last time.Time
+		err = tx.QueryRow(`SELECT failures, last_failure_at FROM login_failures WHERE scope = $1 AND key = $2 FOR UPDATE`,
+			l.scope, keys[l.scope]).Scan(&failures, &last)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-			return
+			return 0, 0, err
 		}
-
-		// Return the file URL
-		fileURL := fmt.Sprintf("/uploads/%s", newFilename)
-		c.JSON(http.StatusOK, gin.H{"url": fileURL})
-	})
-
-	// Create entry endpoint
-	r.POST("/entries", func(c *gin.Context) {
-		var in EntryRequest
-		if err := c.ShouldBindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		// A failure after a quiet window starts the count over
+		if now.Sub(last) > l.limit.Window {
+			failures = 0
 		}
+		wait = max(wait, l.limit.Wait(failures, last, now))
+		counts[l.scope] = failures + 1
+	}
+	if wait > 0 {
+		return wait, 0, nil
+	}
 
-		// Convert user_id string to int
-		userID, err := strconv.Atoi(in.UserID)
+	for _, l := range loginLimits {
+		_, err := tx.Exec(`UPDATE login_failures SET failures = $3, last_failure_at = NOW() WHERE scope = $1 AND key = $2`,
+			l.scope, keys[l.scope], counts[l.scope])
 		if err != nil {
-			// Default user for now
-			userID = 1
+			return 0, 0, err
 		}
+	}
+	return 0, counts["account"], tx.Commit()
+}
 
-		// Convert photos slice to PostgreSQL array format
-		photosArray := "{}"
-		if len(in.Photos) > 0 {
-			photosArray = "{\"" + strings.Join(in.Photos, "\",\"") + "\"}"
-		}
+// Give back an attempt from reserveLoginAttempt that didn't fail. Once the whole login is done
+// the account's count is cleared, the address keeps the failures it had
+func releaseLoginAttempt(email, ip string, complete bool) {
+	account := `UPDATE login_failures SET failures = GREATEST(failures - 1, 0) WHERE scope = 'account' AND key = $1`
+	if complete {
+		account = `DELETE FROM login_failures WHERE scope = 'account' AND key = $1`
+	}
+	_, err := db.Exec(account, email)
+	if err == nil {
+		_, err = db.Exec(`UPDATE login_failures SET failures = GREATEST(failures - 1, 0) WHERE scope = 'ip' AND key = $1`, ip)
+	}
+	if err != nil {
+		log.Printf("Failed to release login attempt: %v", err)
+	}
+}
 
-		query := `
-		INSERT INTO entries (user_id, title, content, location, photos, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
-		RETURNING id`
+// Write a failed login to the audit log, failures is the account's count from reserveLoginAttempt.
+// The lock is logged by the failure that causes it, not again for every one after
+func recordLoginFailure(email, ip, reason string, failures int) {
+	log.Printf("audit: login failed email=%q ip=%s reason=%s", email, ip, reason)
+	if limit := domain.AccountLoginLimit; limit.Locked(failures) && !limit.Locked(failures-1) {
+		log.Printf("audit: login locked email=%q for %s after %d failures", email, limit.LockFor, failures)
+	}
+}
 
-		var entryID int
-		err = db.QueryRow(query, userID, in.Title, in.Content, in.Location, photosArray, time.Now()).Scan(&entryID)
-		if err != nil {
-			log.Printf("Failed to insert entry: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create entry"})
-			return
+// Store a new hash of a user's password made with the current hasher. Matching the old hash
+// means a password changed in the meantime isn't overwritten
+func rehashPassword(userID int, password, oldHash string) {
+	hash, err := domain.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", userID, err)
+		return
+	}
+	_, err = db.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`, hash, userID, oldHash)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", userID, err)
+	}
+}
+
+// Turn a login away until wait has passed
+func loginThrottled(c *gin.Context, email string, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	log.Printf("audit: login throttled email=%q ip=%s retry_after=%ds", email, c.ClientIP(), secs)
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, please try again later", "retry_after": secs})
+}
+
+// Respond to a successful login with a new session
+func loginSucceeded(c *gin.Context, userID int, username string) {
+	token, err := createSession(userID)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Login successful",
+		"user_id":  userID,
+		"username": username,
+		"token":    token,
+	})
+}
+
+// Start a login that still needs its second factor, the returned token goes back with the code.
+// A user has one challenge at a time: logging in again replaces it but keeps its tries and expiry,
+// so entering the password over and over doesn't buy more guesses at the code
+func createLoginChallenge(userID int) (string, error) {
+	token, hash, err := domain.NewSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var attempts int
+	var expires sql.NullTime
+	err = tx.QueryRow(`
+	WITH replaced AS (DELETE FROM login_challenges WHERE user_id = $1 RETURNING attempts, expires_at)
+	SELECT COALESCE(MAX(attempts), 0), MAX(expires_at) FROM replaced WHERE expires_at > NOW()`, userID).
+		Scan(&attempts, &expires)
+	if err != nil {
+		return "", err
+	}
+	if !expires.Valid {
+		expires.Time = time.Now().Add(domain.LoginChallengeTTL)
+	}
+	_, err = tx.Exec(`INSERT INTO login_challenges (token_hash, user_id, attempts, expires_at) VALUES ($1, $2, $3, $4)`,
+		hash, userID, attempts, expires.Time)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// Check a signed in user's second factor (and password, unless it's empty) before changing their
+// two-factor settings. Guesses count against the same per-account limit as logins so a stolen
+// session can't be used to brute force the code. Writes the error response when it returns false
+func reauthenticate(c *gin.Context, userID int, password, code string) bool {
+	var email, passwordHash string
+	err := db.QueryRow(`SELECT LOWER(email), password_hash FROM users WHERE id = $1`, userID).Scan(&email, &passwordHash)
+	if err != nil {
+		log.Printf("Database query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return false
+	}
+
+	ip := c.ClientIP()
+	wait, failures, err := reserveLoginAttempt(email, ip)
+	if err != nil {
+		log.Printf("Database query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return false
+	}
+	if wait > 0 {
+		loginThrottled(c, email, wait)
+		return false
+	}
+
+	if password != "" && !domain.CheckPasswordHash(password, passwordHash) {
+		recordLoginFailure(email, ip, "wrong password", failures)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+		return false
+	}
+	ok, err := checkSecondFactor(userID, code)
+	if err != nil {
+		log.Printf("Failed to check second factor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return false
+	}
+	if !ok {
+		recordLoginFailure(email, ip, "wrong 2fa code", failures)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return false
+	}
+
+	releaseLoginAttempt(email, ip, true)
+	return true
+}
+
+// Check a second factor for a user with two-factor authentication on. code is either from their
+// authenticator app (each one works once) or an unused recovery code, which is used up
+func checkSecondFactor(userID int, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	var secret string
+	err := db.QueryRow(`SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL`, userID).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := domain.CheckTOTP(secret, code, time.Now()); ok {
+		res, err := db.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`,
+			step, userID)
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n == 1, nil
+	}
+
+	res, err := db.Exec(`UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, domain.HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// Replace a user's recovery codes with a new set, returning the codes (they can't be shown again)
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, hashes, err := domain.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, h); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Middleware that requires a valid "Authorization: Bearer <token>" header
+func requireAuth(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var userID int
+	sessionHash := domain.HashToken(token)
+	err := db.QueryRow(`SELECT user_id FROM sessions WHERE token_hash = $1 AND expires_at > NOW()`,
+		sessionHash).Scan(&userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Session lookup failed: %v", err)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+		return
+	}
+
+	c.Set("userID", userID)
+	c.Set("sessionHash", sessionHash)
+	c.Next()
+}
+
+// Middleware for /events, the browser's EventSource can't send the Authorization header so a
+// ticket from POST /events/ticket in ?ticket= opens the stream too. A ticket works once
+func requireStreamAuth(c *gin.Context) {
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		requireAuth(c)
+		return
+	}
+
+	var userID int
+	var sessionHash string
+	err := db.QueryRow(`
+	DELETE FROM stream_tickets t USING sessions s
+	WHERE t.token_hash = $1 AND t.expires_at > NOW() AND s.token_hash = t.session_hash AND s.expires_at > NOW()
+	RETURNING s.user_id, s.token_hash`, domain.HashToken(ticket)).Scan(&userID, &sessionHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Stream ticket lookup failed: %v", err)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+		return
+	}
+
+	c.Set("userID", userID)
+	c.Set("sessionHash", sessionHash)
+	c.Next()
+}
+
+// Middleware that identifies the caller when a valid session is sent but lets anonymous requests through
+func optionalAuth(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.Next()
+		return
+	}
+
+	var userID int
+	err := db.QueryRow(`SELECT user_id FROM sessions WHERE token_hash = $1 AND expires_at > NOW()`,
+		domain.HashToken(token)).Scan(&userID)
+	if err == nil {
+		c.Set("userID", userID)
+	} else if err != sql.ErrNoRows {
+		log.Printf("Session lookup failed: %v", err)
+	}
+	c.Next()
+}
+
+// The authenticated user's ID, only valid behind requireAuth (0 for anonymous callers behind optionalAuth)
+func currentUserID(c *gin.Context) int {
+	return c.GetInt("userID")
+}
+
+// Set up outgoing mail from SMTP_ADDR/SMTP_FROM (logging messages instead when unset) and the link signing secret
+func initMail() {
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+	appURL = strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:5173"
+	}
+
+	if secret := os.Getenv("APP_SECRET"); secret != "" {
+		appSecret = []byte(secret)
+	} else {
+		// Links keep working until restart, and only on this replica
+		log.Println("APP_SECRET is not set, using a random secret for signed links")
+		appSecret = make([]byte, 32)
+		if _, err := rand.Read(appSecret); err != nil {
+			log.Fatalf("Failed to generate secret: %v", err)
+		}
+	}
+
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		log.Println("SMTP_ADDR is not set, outgoing mail will be logged instead of sent")
+		mailer = mail.LogMailer{}
+		return
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "Travel Journal <no-reply@localhost>"
+	}
+	mailer = mail.SMTPMailer{
+		Addr:     addr,
+		From:     from,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+// Email a user a fresh verification link, in the background so the request doesn't wait on SMTP
+func sendVerificationEmail(userID int, username, email string) {
+	if _, err := db.Exec(`UPDATE users SET verification_sent_at = NOW() WHERE id = $1`, userID); err != nil {
+		log.Printf("Failed to record verification email: %v", err)
+	}
+
+	token := domain.SignEmailToken(appSecret, domain.PurposeVerifyEmail, userID, email, time.Now().Add(domain.VerifyEmailTTL))
+	link := publicURL + "/verify-email?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your email for Travel Journal",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to confirm your email address and finish setting up your journal:\n\n%s\n\n"+
+			"The link works for %d hours. If you didn't sign up, you can ignore this email.\n",
+			username, link, int(domain.VerifyEmailTTL.Hours())),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		}
+	}()
+}
+
+// Email a reset link to email if it belongs to an account that hasn't been sent one in the last resendInterval
+func requestPasswordReset(email string) {
+	var userID int
+	var username string
+	err := db.QueryRow(`
+	SELECT id, username, email FROM users u
+	WHERE LOWER(email) = $1 AND NOT EXISTS (
+		SELECT 1 FROM password_resets r WHERE r.user_id = u.id AND r.created_at > $2
+	)`, email, time.Now().Add(-resendInterval)).Scan(&userID, &username, &email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Database query failed: %v", err)
+		return
+	}
+	if err := sendPasswordReset(userID, username, email); err != nil {
+		log.Printf("Failed to start password reset for user %d: %v", userID, err)
+	}
+}
+
+// Email a user a link to reset their password, replacing any earlier link that hasn't been used
+func sendPasswordReset(userID int, username, email string) error {
+	token, hash, err := domain.NewResetToken()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hash, userID, time.Now().Add(domain.PasswordResetTTL))
+	if err != nil {
+		return err
+	}
+
+	link := appURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      email,
+		Subject: "Reset your Travel Journal password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. Open this link to choose a new one:\n\n%s\n\n"+
+			"The link works once, for %d minutes. If it wasn't you, ignore this email and your password stays the same.\n",
+			username, link, int(domain.PasswordResetTTL.Minutes())),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return mailer.Send(ctx, msg)
+}
+
+// Load the place dataset used for reverse geocoding (GEONAMES_PATH overrides the bundled list)
+func initGeocoder() {
+	var err error
+	geocoder, err = geo.LoadGeocoder(os.Getenv("GEONAMES_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load place data: %v", err)
+	}
+	log.Printf("Loaded %d places for reverse geocoding", geocoder.Len())
+}
+
+// Fill in country codes and places (and empty locations) for entries saved before geocoding existed
+func backfillGeocodes() {
+	rows, err := db.Query(`
+	SELECT id, latitude, longitude, COALESCE(location, '')
+	FROM entries
+	WHERE latitude IS NOT NULL AND geocoded_at IS NULL`)
+	if err != nil {
+		log.Printf("Geocode backfill failed: %v", err)
+		return
+	}
+
+	type pending struct {
+		id       int
+		point    geo.Point
+		location string
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.point.Lat, &p.point.Lng, &p.location); err != nil {
+			log.Printf("Geocode backfill failed: %v", err)
+			rows.Close()
+			return
+		}
+		todo = append(todo, p)
+	}
+	rows.Close()
+
+	for _, p := range todo {
+		countryCode, place := reverseGeocode(p.point)
+		location := p.location
+		if location == "" && place != nil {
+			location = place.Label()
+		}
+		if _, err := db.Exec(`
+		UPDATE entries SET country_code = $1, place = $2, location = $3, geocoded_at = NOW()
+		WHERE id = $4`, countryCode, placeLabel(place), location, p.id); err != nil {
+			log.Printf("Geocode backfill failed for entry %d: %v", p.id, err)
+		}
+	}
+	if len(todo) > 0 {
+		log.Printf("Geocode backfill checked %d entries", len(todo))
+	}
+}
+
+// The country at p, from the nearest place within geocodeCountryKM, and that place
+// itself only if it's within geocodeCityKM. Both are nil when nothing is near
+func reverseGeocode(p geo.Point) (*string, *geo.Place) {
+	nearest, ok := geocoder.Nearest(p, geocodeCountryKM)
+	if !ok {
+		return nil, nil
+	}
+	if geo.DistanceKM(p, nearest.Point) > geocodeCityKM {
+		return &nearest.CountryCode, nil
+	}
+	return &nearest.CountryCode, &nearest
+}
+
+// The label stored in entries.place, nil when no place was found
+func placeLabel(place *geo.Place) *string {
+	if place == nil {
+		return nil
+	}
+	label := place.Label()
+	return &label
+}
+
+// Hard-delete entries that have been in the trash longer than trashRetention, checking once an hour
+func purgeTrashLoop() {
+	for {
+		n, err := purgeEntries(`deleted_at < $1`, time.Now().Add(-trashRetention))
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d entries from the trash", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// Delete failed login counts past their window and login challenges and stream tickets that have expired, once an hour
+func purgeLoginStateLoop() {
+	var window time.Duration
+	for _, l := range loginLimits {
+		window = max(window, l.limit.Window)
+	}
+	for {
+		if _, err := db.Exec(`DELETE FROM login_failures WHERE last_failure_at < $1`, time.Now().Add(-window)); err != nil {
+			log.Printf("Login failure purge failed: %v", err)
+		}
+		if _, err := db.Exec(`DELETE FROM login_challenges WHERE expires_at < NOW()`); err != nil {
+			log.Printf("Login challenge purge failed: %v", err)
+		}
+		if _, err := db.Exec(`DELETE FROM stream_tickets WHERE expires_at < NOW()`); err != nil {
+			log.Printf("Stream ticket purge failed: %v", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func main() {
+	// Initialize database
+	initDB()
+	defer db.Close()
+
+	initDummyPasswordHash()
+	hub = events.NewHub(db, databaseURL())
+	initMail()
+
+	initGeocoder()
+	go backfillGeocodes()
+	go purgeTrashLoop()
+	go purgeLoginStateLoop()
+
+	// Initialize Gin router
+	r := gin.Default()
+
+	// Login limits per address need the real client IP. Gin trusts X-Forwarded-For from anyone
+	// by default, which would let a client pick its own address, so only the proxies listed
+	// in TRUSTED_PROXIES are believed and without it the connection's address is used
+	var proxies []string
+	if list := os.Getenv("TRUSTED_PROXIES"); list != "" {
+		for _, p := range strings.Split(list, ",") {
+			proxies = append(proxies, strings.TrimSpace(p))
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// CORS middleware
+	r.Use(func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag")
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	})
+
+	// Serve static files (uploaded photos)
+	r.Static("/uploads", "./public/uploads")
+
+	// Health check endpoint
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// Photo upload endpoint
+	r.POST("/upload", func(c *gin.Context) {
+		file, header, err := c.Request.FormFile("photo")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+			return
+		}
+		defer file.Close()
+
+		// Validate file type
+		filename := header.Filename
+		ext := strings.ToLower(filepath.Ext(filename))
+		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only JPG, PNG, and GIF files are allowed"})
+			return
+		}
+
+		// Uploads are served to anyone with the URL, so the name has to be unguessable
+		// for photos on private and link-only entries to stay private
+		newFilename := strings.ToLower(rand.Text()) + ext
+		filepath := fmt.Sprintf("./public/uploads/%s", newFilename)
+
+		// Create uploads directory if it doesn't exist
+		os.MkdirAll("./public/uploads", 0755)
+
+		// Save file
+		out, err := os.Create(filepath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			return
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			return
+		}
+
+		// Compute placeholder metadata from the saved file
+		fileURL := fmt.Sprintf("/uploads/%s", newFilename)
+		photo, err := describePhoto(filepath, fileURL)
+		if err != nil {
+			out.Close()
+			os.Remove(filepath)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image file"})
+			return
+		}
+
+		_, err = db.Exec(`
+		INSERT INTO photos (url, width, height, blurhash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (url) DO UPDATE SET width = $2, height = $3, blurhash = $4`,
+			photo.URL, photo.Width, photo.Height, photo.BlurHash)
+		if err != nil {
+			log.Printf("Failed to save photo metadata: %v", err)
+		}
+
+		// Return the file URL along with its metadata
+		c.JSON(http.StatusOK, photo)
+	})
+
+	// Get entries for a user
+	r.GET("/entries/:userId", optionalAuth, func(c *gin.Context) {
+		userIDStr := c.Param("userId")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		query := `
+		SELECT ` + entryColumns + `
+		FROM entries 
+		WHERE user_id = $1 AND ` + publishedOnly
+		args := []any{userID}
+
+		// Other people only see what the author made public
+		if userID != currentUserID(c) {
+			query += " AND visibility = 'public'"
+		}
+
+		// Optional ?country=JP filter
+		if country := strings.ToUpper(c.Query("country")); country != "" {
+			args = append(args, country)
+			query += fmt.Sprintf(" AND country_code = $%d", len(args))
+		}
+
+		// Optional ?tag=beach&tag=food (or tag=beach,food) filter, &match=all requires every tag
+		var tags []string
+		for _, raw := range c.QueryArray("tag") {
+			tags = append(tags, strings.Split(raw, ",")...)
+		}
+		if tags, err = domain.NormalizeTags(tags); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(tags) > 0 {
+			args = append(args, pq.Array(tags))
+			tagQuery := fmt.Sprintf(`
+			SELECT et.entry_id FROM entry_tags et JOIN tags t ON t.id = et.tag_id
+			WHERE t.name = ANY($%d)`, len(args))
+			switch c.DefaultQuery("match", "any") {
+			case "any":
+			case "all":
+				args = append(args, len(tags))
+				tagQuery += fmt.Sprintf(" GROUP BY et.entry_id HAVING COUNT(*) = $%d", len(args))
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "match must be any or all"})
+				return
+			}
+			query += " AND id IN (" + tagQuery + ")"
+		}
+		query += " ORDER BY occurred_at DESC"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		var entries []Entry
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+
+		attachEntryDetails(entries)
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// Get single entry
+	r.GET("/entry/:id", optionalAuth, func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+
+		entries := []Entry{entry}
+		attachEntryDetails(entries)
+		c.JSON(http.StatusOK, entries[0])
+	})
+
+	// Comments on an entry as a thread, replies nested under their parent
+	r.GET("/entry/:id/comments", optionalAuth, func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+		SELECT `+commentColumns+`
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.entry_id = $1
+		ORDER BY c.created_at, c.id`, entry.ID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		var comments []Comment
+		for rows.Next() {
+			var comment Comment
+			if comment, err = scanComment(rows); err != nil {
+				break
+			}
+			comments = append(comments, comment)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, commentThreads(comments))
+	})
+
+	// Read-only view of an entry through a share link
+	r.GET("/s/:token", func(c *gin.Context) {
+		// Private entries can't be read through links even if one was made while they were shared
+		var entryID int
+		err := db.QueryRow(`
+		SELECT e.id
+		FROM share_links s
+		JOIN entries e ON e.id = s.entry_id
+		WHERE s.token_hash = $1 AND s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > NOW())
+			AND e.visibility IN ('link', 'public') AND e.status = 'published' AND e.deleted_at IS NULL`,
+			domain.HashToken(c.Param("token"))).Scan(&entryID)
+		if err == nil {
+			var entry Entry
+			if entry, err = loadEntry(entryID); err == nil {
+				entries := []Entry{entry}
+				attachEntryDetails(entries)
+				c.Header("Cache-Control", "private, no-store")
+				c.JSON(http.StatusOK, sharedEntry(entries[0]))
+				return
+			}
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "This link is invalid or has expired"})
+	})
+
+	// Open the link from the verification email
+	r.GET("/verify-email", func(c *gin.Context) {
+		token := c.Query("token")
+		userID, err := domain.EmailTokenUser(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var email string
+		var verified bool
+		err = db.QueryRow(`SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&email, &verified)
+		if err == nil {
+			err = domain.CheckEmailToken(appSecret, domain.PurposeVerifyEmail, token, email, time.Now())
+		}
+		if err != nil {
+			if err != sql.ErrNoRows && err != domain.ErrInvalidToken {
+				log.Printf("Database query failed: %v", err)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": domain.ErrInvalidToken.Error()})
+			return
+		}
+
+		if !verified {
+			if _, err := db.Exec(`UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email_verified_at IS NULL`, userID); err != nil {
+				log.Printf("Failed to verify email: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified, you can log in now", "email_verified": true})
+	})
+
+	// Send another verification link, the answer is the same whether or not the address has an account
+	r.POST("/verify-email/resend", func(c *gin.Context) {
+		var in EmailRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var userID int
+		var username, email string
+		err := db.QueryRow(`
+		SELECT id, username, email FROM users
+		WHERE LOWER(email) = $1 AND email_verified_at IS NULL
+			AND (verification_sent_at IS NULL OR verification_sent_at < $2)`,
+			normalizeEmail(in.Email), time.Now().Add(-resendInterval)).Scan(&userID, &username, &email)
+		switch {
+		case err == nil:
+			sendVerificationEmail(userID, username, email)
+		case err != sql.ErrNoRows:
+			log.Printf("Database query failed: %v", err)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If that address is waiting to be verified, a new link is on its way"})
+	})
+
+	// Email a password reset link, the answer is the same whether or not the address has an account
+	r.POST("/password/forgot", func(c *gin.Context) {
+		var in EmailRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Everything happens after responding, so the response takes as long whether or not the address has an account
+		go requestPasswordReset(normalizeEmail(in.Email))
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If that address has an account, a reset link is on its way"})
+	})
+
+	// Set a new password with a reset token, which also signs the account out everywhere
+	r.POST("/password/reset", func(c *gin.Context) {
+		var in PasswordResetRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Marking the token used in the same statement that finds it means it can only ever be used once.
+		// It's checked before the password is hashed so made up tokens don't cost a hash
+		var userID int
+		err = tx.QueryRow(`
+		UPDATE password_resets SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, domain.HashToken(in.Token)).Scan(&userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This reset link is invalid or has expired"})
+			return
+		}
+		var hashedPassword string
+		if err == nil {
+			hashedPassword, err = domain.HashPassword(in.Password)
+		}
+		// Opening the emailed link proves the address too
+		if err == nil {
+			_, err = tx.Exec(`
+			UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW())
+			WHERE id = $2`, hashedPassword, userID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to reset password: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password updated, log in with your new password"})
+	})
+
+	// Register endpoint
+	r.POST("/register", func(c *gin.Context) {
+		var in RegisterRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		in.Username = strings.TrimSpace(in.Username)
+		in.Email = normalizeEmail(in.Email)
+		if in.Username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+			return
+		}
+
+		// Hash the password
+		hashedPassword, err := domain.HashPassword(in.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+
+		// The unique index on LOWER(email) also catches older accounts that differ from the new address only by case
+		query := `INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id`
+
+		var userID int
+		err = db.QueryRow(query, in.Username, in.Email, hashedPassword).Scan(&userID)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+			} else {
+				log.Printf("Failed to register user: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Registration failed"})
+			}
+			return
+		}
+
+		// The account stays pending until the emailed link is opened
+		sendVerificationEmail(userID, in.Username, in.Email)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":        "User registered successfully, check your email to verify your address",
+			"user_id":        userID,
+			"email_verified": false,
+		})
+	})
+
+	// Login endpoint
+	r.POST("/login", func(c *gin.Context) {
+		var in LoginRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		email, ip := normalizeEmail(in.Email), c.ClientIP()
+
+		// Checked before the password so a locked out client doesn't cost a hash
+		wait, failures, err := reserveLoginAttempt(email, ip)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+		if wait > 0 {
+			loginThrottled(c, email, wait)
+			return
+		}
+
+		query := `SELECT id, username, password_hash, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
+		FROM users WHERE LOWER(email) = $1`
+
+		var userID int
+		var username, passwordHash string
+		var verified, twoFactor bool
+		err = db.QueryRow(query, email).Scan(&userID, &username, &passwordHash, &verified, &twoFactor)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				// Do the same work as for a wrong password so the timing doesn't tell which emails have accounts
+				domain.CheckPasswordHash(in.Password, dummyPasswordHash)
+				recordLoginFailure(email, ip, "unknown email", failures)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			} else {
+				log.Printf("Database query failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			}
+			return
+		}
+
+		// Verify password
+		if !domain.CheckPasswordHash(in.Password, passwordHash) {
+			recordLoginFailure(email, ip, "wrong password", failures)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			return
+		}
+
+		// With two-factor authentication the account's count isn't cleared until the code is right too,
+		// or knowing the password would reset the backoff on guessing codes
+		releaseLoginAttempt(email, ip, !twoFactor)
+
+		// The password is known to be right here, so a hash from an older algorithm or settings can be replaced
+		if domain.NeedsRehash(passwordHash) {
+			rehashPassword(userID, in.Password, passwordHash)
+		}
+
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first", "email_verified": false})
+			return
+		}
+
+		// With two-factor authentication the password alone doesn't get a session,
+		// the client sends the challenge back to /login/2fa with a code
+		if twoFactor {
+			challenge, err := createLoginChallenge(userID)
+			if err != nil {
+				log.Printf("Failed to create login challenge: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"two_factor_required": true,
+				"challenge":           challenge,
+				"expires_in":          int(domain.LoginChallengeTTL.Seconds()),
+			})
+			return
+		}
+
+		loginSucceeded(c, userID, username)
+	})
+
+	// Second step of a login with two-factor authentication
+	r.POST("/login/2fa", func(c *gin.Context) {
+		var in LoginCodeRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Every try counts against the challenge, once they're used up the password has to be entered again
+		var userID int
+		var username, email string
+		err := db.QueryRow(`
+		UPDATE login_challenges l SET attempts = attempts + 1
+		FROM users u
+		WHERE l.token_hash = $1 AND l.expires_at > NOW() AND l.attempts < $2 AND u.id = l.user_id
+		RETURNING u.id, u.username, LOWER(u.email)`, domain.HashToken(in.Challenge), domain.LoginChallengeAttempts).
+			Scan(&userID, &username, &email)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired or out of tries, please sign in again in a few minutes"})
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+
+		// Wrong codes count against the account like wrong passwords
+		ip := c.ClientIP()
+		wait, failures, err := reserveLoginAttempt(email, ip)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+		if wait > 0 {
+			loginThrottled(c, email, wait)
+			return
+		}
+
+		ok, err := checkSecondFactor(userID, in.Code)
+		if err != nil {
+			log.Printf("Failed to check second factor: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+		if !ok {
+			recordLoginFailure(email, ip, "wrong 2fa code", failures)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		if _, err := db.Exec(`DELETE FROM login_challenges WHERE token_hash = $1`, domain.HashToken(in.Challenge)); err != nil {
+			log.Printf("Failed to delete login challenge: %v", err)
+		}
+		releaseLoginAttempt(email, ip, true)
+		loginSucceeded(c, userID, username)
+	})
+
+	// Routes below act on behalf of the logged in user
+	auth := r.Group("/", requireAuth)
+
+	// Create entry endpoint
+	auth.POST("/entries", func(c *gin.Context) {
+		var in EntryRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID := currentUserID(c)
+
+		if err := validateCoordinates(in.Latitude, in.Longitude); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tags, err := domain.NormalizeTags(in.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if in.Status == "" {
+			in.Status = StatusPublished
+		}
+		if in.Status != StatusDraft && in.Status != StatusPublished {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft or published"})
+			return
+		}
+
+		if in.Visibility == "" {
+			in.Visibility = domain.VisibilityPrivate
+		}
+		if err := domain.ValidateVisibility(in.Visibility); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The entry's time zone defaults to the author's profile setting
+		if in.TimeZone == "" {
+			if err := db.QueryRow(`SELECT time_zone FROM users WHERE id = $1`, userID).Scan(&in.TimeZone); err != nil {
+				in.TimeZone = "UTC"
+			}
+		}
+		loc, err := loadLocation(in.TimeZone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+			return
+		}
+		occurredAt := time.Now()
+		if in.OccurredAt != "" {
+			if occurredAt, err = parseOccurredAt(in.OccurredAt, loc); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "occurred_at must be RFC3339 or YYYY-MM-DDTHH:MM"})
+				return
+			}
+		}
+
+		// Resolve coordinates to a country and place, and a location label if none was typed
+		var countryCode *string
+		var place *geo.Place
+		var geocodedAt *time.Time
+		if in.Latitude != nil {
+			now := time.Now()
+			geocodedAt = &now
+			countryCode, place = reverseGeocode(geo.Point{Lat: *in.Latitude, Lng: *in.Longitude})
+			if place != nil && strings.TrimSpace(in.Location) == "" {
+				in.Location = place.Label()
+			}
+		}
+
+		// Entries can only be filed under trips the author owns or edits
+		if in.TripID != nil {
+			role, err := tripRole(*in.TripID, userID)
+			if err != nil || !domain.HasRole(role, domain.RoleEditor) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trip ID"})
+				return
+			}
+		}
+
+		// Convert photos slice to PostgreSQL array format
+		photosArray := "{}"
+		if len(in.Photos) > 0 {
+			photosArray = "{\"" + strings.Join(in.Photos, "\",\"") + "\"}"
+		}
+
+		query := `
+		INSERT INTO entries (user_id, trip_id, title, content, location, country_code, place, geocoded_at, latitude, longitude, photos, occurred_at, time_zone, status, visibility, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) 
+		RETURNING id`
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create entry"})
+			return
+		}
+		defer tx.Rollback()
+
+		var entryID int
+		err = tx.QueryRow(query, userID, in.TripID, in.Title, in.Content, in.Location, countryCode, placeLabel(place), geocodedAt, in.Latitude, in.Longitude, photosArray, occurredAt, in.TimeZone, in.Status, in.Visibility, time.Now()).Scan(&entryID)
+		if err == nil {
+			err = setEntryTags(tx, entryID, tags)
+		}
+		if err == nil {
+			err = recordRevision(tx, entryID, userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to insert entry: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create entry"})
+			return
+		}
+		publishEntryEvent(events.EntryCreated, entryID, 0)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Entry created successfully",
+			"id":      entryID,
+			"status":  in.Status,
+			"version": 1,
+		})
+	})
+
+	// Entries within radius_km of a point, closest first
+	auth.GET("/entries/nearby", func(c *gin.Context) {
+		lat, ok := floatQuery(c, "lat", nil)
+		if !ok {
+			return
+		}
+		lng, ok := floatQuery(c, "lng", nil)
+		if !ok {
+			return
+		}
+		defaultRadius := 25.0
+		radius, ok := floatQuery(c, "radius_km", &defaultRadius)
+		if !ok {
+			return
+		}
+		if err := geo.Validate(lat, lng); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if radius <= 0 || radius > math.Pi*geo.EarthRadiusKM {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "radius_km is out of range"})
+			return
+		}
+
+		center := geo.Point{Lat: lat, Lng: lng}
+		candidates, err := entriesInBox(currentUserID(c), geo.BoundingBox(center, radius))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		// The box is only a coarse filter, trim the corners off with the real distance
+		entries := []Entry{}
+		for _, entry := range candidates {
+			d := geo.DistanceKM(center, geo.Point{Lat: *entry.Latitude, Lng: *entry.Longitude})
+			if d <= radius {
+				entry.DistanceKM = &d
+				entries = append(entries, entry)
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return *entries[i].DistanceKM < *entries[j].DistanceKM })
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// Entries inside a bounding box, min_lng > max_lng wraps across the antimeridian
+	auth.GET("/entries/bbox", func(c *gin.Context) {
+		var box geo.Box
+		params := []struct {
+			name string
+			dst  *float64
+		}{
+			{"min_lat", &box.MinLat}, {"min_lng", &box.MinLng},
+			{"max_lat", &box.MaxLat}, {"max_lng", &box.MaxLng},
+		}
+		for _, p := range params {
+			v, ok := floatQuery(c, p.name, nil)
+			if !ok {
+				return
+			}
+			*p.dst = v
+		}
+		if geo.Validate(box.MinLat, box.MinLng) != nil || geo.Validate(box.MaxLat, box.MaxLng) != nil || box.MinLat > box.MaxLat {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bounding box"})
+			return
+		}
+
+		entries, err := entriesInBox(currentUserID(c), box)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// Edit an entry, only the fields present in the body change
+	auth.PATCH("/entry/:id", func(c *gin.Context) {
+		entryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+			return
+		}
+
+		var in EntryPatch
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID := currentUserID(c)
+		var owner int
+		var tripID sql.NullInt64
+		var timeZone, status, title string
+		err = db.QueryRow(`SELECT user_id, trip_id, time_zone, status, title FROM entries WHERE id = $1 AND deleted_at IS NULL`, entryID).
+			Scan(&owner, &tripID, &timeZone, &status, &title)
+		// Editors of a trip can edit its entries, only the author can publish, share or move them
+		role := ""
+		if err == nil && owner != userID && tripID.Valid {
+			role, err = tripRole(int(tripID.Int64), userID)
+		}
+		if err != nil || (owner != userID && !domain.HasRole(role, domain.RoleViewer)) {
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Database query failed: %v", err)
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+			return
+		}
+		if owner != userID && (!domain.HasRole(role, domain.RoleEditor) || in.Status != nil || in.Visibility != nil || in.TripID.Set) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can change this"})
+			return
+		}
+
+		// Collect "column = $n" assignments for the fields that were sent
+		var sets []string
+		var args []any
+		set := func(format string, value any) {
+			args = append(args, value)
+			sets = append(sets, fmt.Sprintf(format, len(args)))
+		}
+
+		// Drafts are saved as-is so autosave never fails on a half-written entry,
+		// the title is only required once the entry is (or becomes) published
+		if in.Status != nil {
+			if *in.Status != StatusDraft && *in.Status != StatusPublished {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft or published"})
+				return
+			}
+			status = *in.Status
+			set("status = $%d", status)
+		}
+		if in.Visibility != nil {
+			if err := domain.ValidateVisibility(*in.Visibility); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			set("visibility = $%d", *in.Visibility)
+		}
+		if in.Title != nil {
+			title = *in.Title
+			set("title = $%d", title)
+		}
+		if status == StatusPublished && strings.TrimSpace(title) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title cannot be empty"})
+			return
+		}
+		if in.Content != nil {
+			set("content = $%d", *in.Content)
+		}
+		if in.Location != nil {
+			set("location = $%d", *in.Location)
+		}
+		if in.Photos != nil {
+			set("photos = $%d", pq.Array(*in.Photos))
+		}
+		if in.TripID.Set && in.TripID.Value == nil {
+			sets = append(sets, "trip_id = NULL")
+		} else if in.TripID.Set {
+			role, err := tripRole(*in.TripID.Value, userID)
+			if err != nil || !domain.HasRole(role, domain.RoleEditor) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trip ID"})
+				return
+			}
+			set("trip_id = $%d", *in.TripID.Value)
+		}
+		if in.TimeZone != nil {
+			if _, err := loadLocation(*in.TimeZone); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+				return
+			}
+			timeZone = *in.TimeZone
+			set("time_zone = $%d", timeZone)
+		}
+		if in.OccurredAt != nil {
+			loc, err := loadLocation(timeZone)
+			if err != nil {
+				loc = time.UTC
+			}
+			occurredAt, err := parseOccurredAt(*in.OccurredAt, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "occurred_at must be RFC3339 or YYYY-MM-DDTHH:MM"})
+				return
+			}
+			set("occurred_at = $%d", occurredAt)
+		}
+		// Both are sent to move the entry, or both null to clear them
+		if in.Latitude.Set != in.Longitude.Set {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Latitude and longitude must be provided together"})
+			return
+		}
+		if err := validateCoordinates(in.Latitude.Value, in.Longitude.Value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if in.Latitude.Set && in.Latitude.Value == nil {
+			// Clearing the coordinates clears what was resolved from them, a typed location stays
+			sets = append(sets, "latitude = NULL", "longitude = NULL", "country_code = NULL", "place = NULL", "geocoded_at = NULL")
+		} else if in.Latitude.Set {
+			lat, lng := *in.Latitude.Value, *in.Longitude.Value
+			set("latitude = $%d", lat)
+			set("longitude = $%d", lng)
+
+			countryCode, place := reverseGeocode(geo.Point{Lat: lat, Lng: lng})
+			if place != nil && in.Location == nil {
+				set("location = COALESCE(NULLIF(location, ''), $%d)", place.Label())
+			}
+			set("country_code = $%d", countryCode)
+			set("place = $%d", placeLabel(place))
+			set("geocoded_at = $%d", time.Now())
+		}
+
+		var tags []string
+		if in.Tags != nil {
+			if tags, err = domain.NormalizeTags(*in.Tags); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update entry"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Every save bumps the version, If-Match makes the save conditional on the version the client last saw
+		sets = append(sets, "version = version + 1", "updated_at = NOW()")
+		args = append(args, entryID)
+		query := fmt.Sprintf(`UPDATE entries SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+		if ifMatch := strings.Trim(c.GetHeader("If-Match"), `"`); ifMatch != "" {
+			version, err := strconv.Atoi(ifMatch)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match must be an entry version"})
+				return
+			}
+			args = append(args, version)
+			query += fmt.Sprintf(" AND version = $%d", len(args))
+		}
+
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			log.Printf("Failed to update entry: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update entry"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// Someone (another tab, usually) saved a newer version first
+			current, _ := loadEntry(entryID)
+			c.JSON(http.StatusConflict, gin.H{"error": "Entry was changed since version " + c.GetHeader("If-Match"), "entry": current})
+			return
+		}
+
+		if in.Tags != nil {
+			err = setEntryTags(tx, entryID, tags)
+		}
+		if err == nil {
+			err = recordRevision(tx, entryID, userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to update entry: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update entry"})
+			return
+		}
+		publishEntryEvent(events.EntryUpdated, entryID, 0)
+
+		entry, err := loadEntry(entryID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		entries := []Entry{entry}
+		attachEntryDetails(entries)
+		c.Header("ETag", fmt.Sprintf(`"%d"`, entry.Version))
+		c.JSON(http.StatusOK, entries[0])
+	})
+
+	// Move an entry to the trash, it can be restored until it is purged
+	auth.DELETE("/entry/:id", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		if _, err := db.Exec(`UPDATE entries SET deleted_at = NOW() WHERE id = $1`, entryID); err != nil {
+			log.Printf("Failed to delete entry: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete entry"})
+			return
+		}
+
+		publishEntryEvent(events.EntryDeleted, entryID, 0)
+		c.JSON(http.StatusOK, gin.H{"message": "Entry moved to trash"})
+	})
+
+	// Create a share link for an entry, the token is only ever shown in this response
+	auth.POST("/entry/:id/shares", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		var in ShareRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&in); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		ttl := time.Duration(in.ExpiresInHours) * time.Hour
+		if in.ExpiresInHours < 0 || ttl > domain.MaxShareTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours must be between 0 and 8760"})
+			return
+		}
+
+		token, hash, err := domain.NewShareToken()
+		if err != nil {
+			log.Printf("Failed to create share token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+			return
+		}
+		var expiresAt *time.Time
+		if ttl > 0 {
+			t := time.Now().Add(ttl).UTC()
+			expiresAt = &t
+		}
+
+		link := ShareLink{EntryID: entryID, Token: token, URL: "/s/" + token, ExpiresAt: expiresAt}
+		err = db.QueryRow(`
+		INSERT INTO share_links (entry_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`, entryID, hash, expiresAt).Scan(&link.ID, &link.CreatedAt)
+		if err != nil {
+			log.Printf("Failed to create share link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+			return
+		}
+		link.CreatedAt = link.CreatedAt.UTC()
+
+		c.JSON(http.StatusCreated, link)
+	})
+
+	// Share links of an entry, newest first (without their tokens)
+	auth.GET("/entry/:id/shares", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+		SELECT id, entry_id, expires_at, revoked_at, created_at
+		FROM share_links
+		WHERE entry_id = $1
+		ORDER BY created_at DESC`, entryID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		links := []ShareLink{}
+		for rows.Next() {
+			var l ShareLink
+			if err := rows.Scan(&l.ID, &l.EntryID, &l.ExpiresAt, &l.RevokedAt, &l.CreatedAt); err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			links = append(links, l)
+		}
+
+		c.JSON(http.StatusOK, links)
+	})
+
+	// Revoke a share link, it stops working immediately
+	auth.DELETE("/entry/:id/shares/:shareId", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		res, err := db.Exec(`
+		UPDATE share_links SET revoked_at = NOW()
+		WHERE id = $1 AND entry_id = $2 AND revoked_at IS NULL`, c.Param("shareId"), entryID)
+		if err != nil {
+			log.Printf("Failed to revoke share link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+	})
+
+	// History of an entry, newest first
+	auth.GET("/entry/:id/revisions", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		revisions, err := entryRevisions(entryID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, revisions)
+	})
+
+	// Word-level diff between two revisions, ?from=&to= (to defaults to the latest)
+	auth.GET("/entry/:id/revisions/diff", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+
+		from, err := strconv.Atoi(c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a revision number"})
+			return
+		}
+		to := 0
+		if raw := c.Query("to"); raw != "" {
+			if to, err = strconv.Atoi(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a revision number"})
+				return
+			}
+		} else if err := db.QueryRow(`SELECT MAX(rev) FROM entry_revisions WHERE entry_id = $1`, entryID).Scan(&to); err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		a, err := loadRevision(entryID, from)
+		var b Revision
+		if err == nil {
+			b, err = loadRevision(entryID, to)
+		}
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":     a.Rev,
+			"to":       b.Rev,
+			"title":    diff.Words(a.Title, b.Title),
+			"content":  diff.Words(a.Content, b.Content),
+			"location": diff.Words(a.Location, b.Location),
+			"tags":     diff.Words(strings.Join(a.Tags, " "), strings.Join(b.Tags, " ")),
+		})
+	})
+
+	// Put an entry back the way it was at an earlier revision (this adds a new revision, nothing is lost)
+	auth.POST("/entry/:id/revisions/:rev/restore", func(c *gin.Context) {
+		entryID, ok := ownEntryID(c)
+		if !ok {
+			return
+		}
+		rev, err := strconv.Atoi(c.Param("rev"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+		old, err := loadRevision(entryID, rev)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Revisions don't keep the place, it's resolved again from the old coordinates
+		var place *geo.Place
+		if old.Latitude != nil && old.Longitude != nil {
+			_, place = reverseGeocode(geo.Point{Lat: *old.Latitude, Lng: *old.Longitude})
+		}
+
+		// The trip may have been deleted since, in which case the entry stays where it is
+		_, err = tx.Exec(`
+		UPDATE entries SET
+			title = $1, content = $2, location = $3, country_code = $4, latitude = $5, longitude = $6,
+			photos = $7, occurred_at = $8, time_zone = $9, status = COALESCE($12, status), place = $13,
+			geocoded_at = CASE WHEN $5::float8 IS NULL THEN NULL ELSE NOW() END,
+			trip_id = COALESCE((
+				SELECT t.id FROM trips t
+				WHERE t.id = $10 AND (t.user_id = entries.user_id OR EXISTS (
+					SELECT 1 FROM trip_members m
+					WHERE m.trip_id = t.id AND m.user_id = entries.user_id AND m.role = 'editor' AND m.status = 'accepted'))
+			), trip_id),
+			version = version + 1, updated_at = NOW()
+		WHERE id = $11`,
+			old.Title, old.Content, old.Location, nullIfEmpty(old.CountryCode), old.Latitude, old.Longitude,
+			pq.Array(old.Photos), old.OccurredAt, old.TimeZone, old.TripID, entryID, old.Status, placeLabel(place))
+		if err == nil {
+			err = setEntryTags(tx, entryID, old.Tags)
+		}
+		if err == nil {
+			err = recordRevision(tx, entryID, currentUserID(c))
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to restore revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+		publishEntryEvent(events.EntryUpdated, entryID, 0)
+
+		entry, err := loadEntry(entryID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		entries := []Entry{entry}
+		attachEntryDetails(entries)
+		c.JSON(http.StatusOK, entries[0])
+	})
+
+	// The caller's drafts, most recently edited first
+	auth.GET("/me/drafts", func(c *gin.Context) {
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`
+		FROM entries
+		WHERE user_id = $1 AND status = 'draft' AND deleted_at IS NULL
+		ORDER BY updated_at DESC`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// The caller's deleted entries, most recently deleted first
+	auth.GET("/me/trash", func(c *gin.Context) {
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`
+		FROM entries
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, gin.H{
+			"retention_days": int(trashRetention.Hours() / 24),
+			"entries":        entries,
+		})
+	})
+
+	// Take an entry back out of the trash
+	auth.POST("/me/trash/:id/restore", func(c *gin.Context) {
+		entryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+			return
+		}
+
+		res, err := db.Exec(`
+		UPDATE entries SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, entryID, currentUserID(c))
+		if err != nil {
+			log.Printf("Failed to restore entry: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore entry"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found in trash"})
+			return
+		}
+		// Back out of the trash looks like a new entry to anyone watching
+		publishEntryEvent(events.EntryCreated, entryID, 0)
+
+		entry, err := loadEntry(entryID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		entries := []Entry{entry}
+		attachEntryDetails(entries)
+		c.JSON(http.StatusOK, entries[0])
+	})
+
+	// Permanently delete everything in the caller's trash
+	auth.DELETE("/me/trash", func(c *gin.Context) {
+		n, err := purgeEntries(`user_id = $1 AND deleted_at IS NOT NULL`, currentUserID(c))
+		if err != nil {
+			log.Printf("Failed to empty trash: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deleted": n})
+	})
+
+	// Tags the caller has used, with how many entries carry each
+	auth.GET("/me/tags", func(c *gin.Context) {
+		tags, err := namedCounts(`
+		SELECT t.name, COUNT(*)
+		FROM entry_tags et
+		JOIN tags t ON t.id = et.tag_id
+		JOIN entries e ON e.id = et.entry_id
+		WHERE e.user_id = $1 AND e.status = 'published' AND e.deleted_at IS NULL
+		GROUP BY t.name
+		ORDER BY COUNT(*) DESC, t.name`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, tags)
+	})
+
+	// The caller's profile
+	auth.GET("/me", func(c *gin.Context) {
+		var p Profile
+		err := db.QueryRow(`
+		SELECT id, username, email, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL, time_zone
+		FROM users WHERE id = $1`, currentUserID(c)).
+			Scan(&p.ID, &p.Username, &p.Email, &p.EmailVerified, &p.TwoFactorEnabled, &p.TimeZone)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	})
+
+	// Update the caller's settings
+	auth.PATCH("/me", func(c *gin.Context) {
+		var in ProfileRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if in.TimeZone != nil {
+			if _, err := loadLocation(*in.TimeZone); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+				return
+			}
+			if _, err := db.Exec(`UPDATE users SET time_zone = $1 WHERE id = $2`, *in.TimeZone, currentUserID(c)); err != nil {
+				log.Printf("Failed to update profile: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Profile updated"})
+	})
+
+	// Whether two-factor authentication is on and how many recovery codes are left
+	auth.GET("/me/2fa", func(c *gin.Context) {
+		var enabled bool
+		var left int
+		err := db.QueryRow(`
+		SELECT u.totp_enabled_at IS NOT NULL,
+			(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		FROM users u WHERE u.id = $1`, currentUserID(c)).Scan(&enabled, &left)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": enabled, "recovery_codes_left": left})
+	})
+
+	// Start setting up two-factor authentication: a new secret for the authenticator app,
+	// it isn't used for logins until a code from the app is confirmed
+	auth.POST("/me/2fa/setup", func(c *gin.Context) {
+		secret, err := domain.NewTOTPSecret()
+		if err != nil {
+			log.Printf("Failed to create TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+			return
+		}
+
+		var email string
+		err = db.QueryRow(`UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_enabled_at IS NULL RETURNING email`,
+			secret, currentUserID(c)).Scan(&email)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already on"})
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+			return
+		}
+
+		uri := domain.TOTPURI("Travel Journal", email, secret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			log.Printf("Failed to render QR code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": uri,
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		})
+	})
+
+	// Finish setup with a code from the authenticator app, returns the recovery codes (only this once)
+	auth.POST("/me/2fa/confirm", func(c *gin.Context) {
+		var in TwoFactorRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		var secret sql.NullString
+		var enabled bool
+		err := db.QueryRow(`SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&secret, &enabled)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		if enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already on"})
+			return
+		}
+		if !secret.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+			return
+		}
+		step, ok := domain.CheckTOTP(secret.String, strings.TrimSpace(in.Code), time.Now())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn on two-factor authentication"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Checking the secret again means a setup restarted in the meantime can't be enabled with an old code
+		res, err := tx.Exec(`UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1
+		WHERE id = $2 AND totp_secret = $3 AND totp_enabled_at IS NULL`, step, userID, secret.String)
+		if err != nil {
+			log.Printf("Failed to enable 2FA: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn on two-factor authentication"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor setup changed, please start again"})
+			return
+		}
+		codes, err := replaceRecoveryCodes(tx, userID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to enable 2FA: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn on two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication is on. Keep these recovery codes somewhere safe, each works once",
+			"recovery_codes": codes,
+		})
+	})
+
+	// Replace the recovery codes, needs a current code
+	auth.POST("/me/2fa/recovery-codes", func(c *gin.Context) {
+		var in TwoFactorRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+		if !reauthenticate(c, userID, "", in.Code) {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+		defer tx.Rollback()
+
+		codes, err := replaceRecoveryCodes(tx, userID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to replace recovery codes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	})
+
+	// Turn two-factor authentication off, needs the password and a current code or a recovery code
+	auth.DELETE("/me/2fa", func(c *gin.Context) {
+		var in DisableTwoFactorRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+		if !reauthenticate(c, userID, in.Password, in.Code) {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn off two-factor authentication"})
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1`, userID)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM login_challenges WHERE user_id = $1`, userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Failed to disable 2FA: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn off two-factor authentication"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication is off"})
+	})
+
+	// Entries written on this calendar day in earlier years
+	auth.GET("/me/memories", func(c *gin.Context) {
+		userID := currentUserID(c)
+		loc, ok := userLocation(c, userID)
+		if !ok {
+			return
+		}
+
+		day := time.Now().In(loc)
+		if raw := c.Query("date"); raw != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", raw, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+				return
+			}
+			day = parsed
+		}
+
+		// On Feb 28 of a non-leap year also show entries from Feb 29
+		leapDay := day.Month() == time.February && day.Day() == 28 && !isLeapYear(day.Year())
+
+		// "Today" is in the caller's zone, each entry's day is in the zone it was written in
+		local := entryLocalTime
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`, EXTRACT(YEAR FROM `+local+`)::int AS year
+		FROM entries
+		WHERE user_id = $1 AND `+publishedOnly+`
+			AND EXTRACT(YEAR FROM `+local+`) < $2
+			AND (
+				(EXTRACT(MONTH FROM `+local+`) = $3 AND EXTRACT(DAY FROM `+local+`) = $4)
+				OR ($5 AND EXTRACT(MONTH FROM `+local+`) = 2 AND EXTRACT(DAY FROM `+local+`) = 29)
+			)
+		ORDER BY occurred_at DESC`,
+			userID, day.Year(), int(day.Month()), day.Day(), leapDay)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		var entries []Entry
+		var entryYears []int
+		for rows.Next() {
+			var year int
+			entry, err := scanEntry(scanWithExtra{rows, []any{&year}})
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+			entryYears = append(entryYears, year)
+		}
+		attachEntryDetails(entries)
+
+		// Rows come newest first so each year's entries are contiguous
+		years := []MemoryYear{}
+		for i, entry := range entries {
+			if len(years) == 0 || years[len(years)-1].Year != entryYears[i] {
+				years = append(years, MemoryYear{Year: entryYears[i], YearsAgo: day.Year() - entryYears[i]})
+			}
+			years[len(years)-1].Entries = append(years[len(years)-1].Entries, entry)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"date":      day.Format("2006-01-02"),
+			"time_zone": loc.String(),
+			"years":     years,
+		})
+	})
+
+	// Summary statistics for the caller
+	auth.GET("/me/stats", func(c *gin.Context) {
+		stats, err := userStats(currentUserID(c), c.Query("include_drafts") == "true")
+		if err != nil {
+			log.Printf("Failed to compute stats: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute stats"})
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	// Export the caller's located entries as GeoJSON
+	auth.GET("/me/entries.geojson", func(c *gin.Context) {
+		entries, err := entriesInBox(currentUserID(c), geo.Box{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180})
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		c.Header("Content-Type", "application/geo+json")
+		c.Header("Content-Disposition", `attachment; filename="entries.geojson"`)
+		if err := geo.WriteGeoJSON(c.Writer, entryFeatures(entries)); err != nil {
+			log.Printf("Failed to write GeoJSON: %v", err)
+		}
+	})
+
+	// Export a trip's located entries as KML
+	auth.GET("/trips/:id/entries.kml", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleViewer)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`
+		FROM entries
+		WHERE trip_id = $1 AND latitude IS NOT NULL AND `+publishedOnly+`
+		ORDER BY occurred_at`, trip.ID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		var entries []Entry
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+
+		c.Header("Content-Type", "application/vnd.google-earth.kml+xml")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"trip-%d.kml\"", trip.ID))
+		if err := geo.WriteKML(c.Writer, trip.Name, entryFeatures(entries)); err != nil {
+			log.Printf("Failed to write KML: %v", err)
+		}
+	})
+
+	// Create a trip
+	auth.POST("/trips", func(c *gin.Context) {
+		var in TripRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(in.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Trip name is required"})
+			return
+		}
+
+		trip := Trip{UserID: currentUserID(c), Name: in.Name, Description: in.Description, Role: domain.RoleOwner}
+		err := db.QueryRow(`
+		INSERT INTO trips (user_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`, trip.UserID, trip.Name, trip.Description).Scan(&trip.ID, &trip.CreatedAt)
+		if err != nil {
+			log.Printf("Failed to create trip: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, trip)
+	})
+
+	// List the caller's trips, including ones they've joined
+	auth.GET("/me/trips", func(c *gin.Context) {
+		rows, err := db.Query(`
+		SELECT t.id, t.user_id, t.name, t.description, COALESCE(m.role, 'owner'), t.created_at
+		FROM trips t
+		LEFT JOIN trip_members m ON m.trip_id = t.id AND m.user_id = $1 AND m.status = 'accepted'
+		WHERE t.user_id = $1 OR m.user_id IS NOT NULL
+		ORDER BY t.created_at DESC`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		trips := []Trip{}
+		for rows.Next() {
+			var trip Trip
+			if err := rows.Scan(&trip.ID, &trip.UserID, &trip.Name, &trip.Description, &trip.Role, &trip.CreatedAt); err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			trips = append(trips, trip)
+		}
+
+		c.JSON(http.StatusOK, trips)
+	})
+
+	// Get a trip with its entries
+	auth.GET("/trips/:id", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleViewer)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`
+		FROM entries
+		WHERE trip_id = $1 AND `+publishedOnly+`
+		ORDER BY occurred_at`, trip.ID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		attachEntryDetails(entries)
+
+		tracks, err := tripTracks(trip.ID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"trip": trip, "entries": entries, "tracks": tracks})
+	})
+
+	// Import a GPX track into a trip
+	auth.POST("/trips/:id/tracks", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleEditor)
+		if !ok {
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGPXBytes)
+		file, _, err := c.Request.FormFile("gpx")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No GPX file uploaded"})
+			return
+		}
+		defer file.Close()
+
+		parsed, err := geo.ParseGPX(file)
+		if err != nil {
+			if errors.Is(err, geo.ErrEmptyGPX) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GPX file"})
+			}
+			return
+		}
+
+		// The name column is VARCHAR(255), long names are cut to 254 characters plus an ellipsis
+		track := Track{
+			TripID:          trip.ID,
+			Name:            snippet(parsed.Name, 254),
+			DistanceKM:      parsed.DistanceKM,
+			ElevationGainM:  parsed.ElevationGainM,
+			DurationSeconds: parsed.DurationSeconds,
+			StartedAt:       parsed.StartedAt,
+			EndedAt:         parsed.EndedAt,
+			Waypoints:       parsed.Waypoints,
+		}
+		if track.Waypoints == nil {
+			track.Waypoints = []geo.Waypoint{}
+		}
+		for _, seg := range parsed.Segments {
+			points := make([]geo.Point, len(seg))
+			for i, p := range seg {
+				points[i] = p.Point
+			}
+			track.Polylines = append(track.Polylines, geo.EncodePolyline(geo.Simplify(points, trackToleranceM)))
+		}
+
+		waypoints, err := json.Marshal(track.Waypoints)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save track"})
+			return
+		}
+
+		err = db.QueryRow(`
+		INSERT INTO tracks (trip_id, name, distance_km, elevation_gain_m, duration_seconds, started_at, ended_at, polylines, waypoints)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+			track.TripID, track.Name, track.DistanceKM, track.ElevationGainM, track.DurationSeconds,
+			track.StartedAt, track.EndedAt, pq.Array(track.Polylines), waypoints,
+		).Scan(&track.ID, &track.CreatedAt)
+		if err != nil {
+			log.Printf("Failed to save track: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save track"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, track)
+	})
+
+	// Remove a track from a trip
+	auth.DELETE("/trips/:id/tracks/:trackId", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleEditor)
+		if !ok {
+			return
+		}
+
+		res, err := db.Exec(`DELETE FROM tracks WHERE id = $1 AND trip_id = $2`, c.Param("trackId"), trip.ID)
+		if err != nil {
+			log.Printf("Failed to delete track: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete track"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Track not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Track deleted"})
+	})
+
+	// Invite a user to the trip as an editor or viewer, inviting an existing member changes their role
+	auth.POST("/trips/:id/members", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleOwner)
+		if !ok {
+			return
+		}
+
+		var in MemberRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := domain.ValidateMemberRole(in.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The response is the same whether or not anyone matched so invitations can't be used to
+		// find out who has an account. An email match wins over someone using it as their username
+		invited := gin.H{"message": "If that user exists, they've been invited"}
+		member := TripMember{TripID: trip.ID, Role: in.Role, InvitedBy: currentUserID(c)}
+		err := db.QueryRow(`
+		SELECT id, username FROM users
+		WHERE LOWER(email) = LOWER($1) OR username = $1
+		ORDER BY LOWER(email) = LOWER($1) DESC
+		LIMIT 1`, strings.TrimSpace(in.User)).
+			Scan(&member.UserID, &member.Username)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusAccepted, invited)
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite member"})
+			return
+		}
+		if member.UserID == trip.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The owner is already on the trip"})
+			return
+		}
+
+		// A declined invitation can be sent again, an accepted member keeps their place with the new role
+		err = db.QueryRow(`
+		INSERT INTO trip_members (trip_id, user_id, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (trip_id, user_id) DO UPDATE SET
+			role = EXCLUDED.role,
+			invited_by = EXCLUDED.invited_by,
+			status = CASE WHEN trip_members.status = 'accepted' THEN 'accepted' ELSE 'pending' END
+		RETURNING status, created_at, responded_at`, member.TripID, member.UserID, member.Role, member.InvitedBy).
+			Scan(&member.Status, &member.CreatedAt, &member.RespondedAt)
+		if err != nil {
+			log.Printf("Failed to invite member: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite member"})
+			return
+		}
+		if member.Status == domain.MemberPending {
+			notify(notice{userID: member.UserID, actorID: member.InvitedBy, kind: domain.NotifyTripInvite, tripID: &trip.ID})
+		}
+
+		c.JSON(http.StatusAccepted, invited)
+	})
+
+	// People on the trip, the owner also sees pending and declined invitations
+	auth.GET("/trips/:id/members", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleViewer)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+		SELECT m.trip_id, m.user_id, u.username, m.role, m.status, m.invited_by, m.created_at, m.responded_at
+		FROM trip_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.trip_id = $1 AND (m.status = 'accepted' OR $2)
+		ORDER BY m.created_at`, trip.ID, trip.Role == domain.RoleOwner)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		members := []TripMember{}
+		for rows.Next() {
+			var m TripMember
+			if err := rows.Scan(&m.TripID, &m.UserID, &m.Username, &m.Role, &m.Status, &m.InvitedBy, &m.CreatedAt, &m.RespondedAt); err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			members = append(members, m)
+		}
+
+		c.JSON(http.StatusOK, members)
+	})
+
+	// Remove someone from the trip, members can also remove themselves to leave it
+	auth.DELETE("/trips/:id/members/:userId", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleViewer)
+		if !ok {
+			return
+		}
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if trip.Role != domain.RoleOwner && userID != currentUserID(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the trip owner can remove other members"})
+			return
+		}
+
+		res, err := db.Exec(`DELETE FROM trip_members WHERE trip_id = $1 AND user_id = $2`, trip.ID, userID)
+		if err != nil {
+			log.Printf("Failed to remove member: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+	})
+
+	// Comment on an entry the caller can see, parent_id makes it a reply
+	auth.POST("/entry/:id/comments", func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+		if entry.Status != StatusPublished {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drafts can't be commented on"})
+			return
+		}
+
+		var in CommentRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body, err := domain.NormalizeComment(in.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Replies have to stay within the same entry
+		var parentAuthor int
+		if in.ParentID != nil {
+			var parentEntry int
+			err := db.QueryRow(`SELECT entry_id, user_id FROM comments WHERE id = $1`, *in.ParentID).Scan(&parentEntry, &parentAuthor)
+			if err != nil || parentEntry != entry.ID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent comment"})
+				return
+			}
+		}
+
+		var commentID int
+		err = db.QueryRow(`
+		INSERT INTO comments (entry_id, user_id, parent_id, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, entry.ID, currentUserID(c), in.ParentID, body).Scan(&commentID)
+		if err != nil {
+			log.Printf("Failed to create comment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+			return
+		}
+
+		// The entry's author hears about every comment, unless it's a reply to them which they hear about as that
+		if parentAuthor != 0 {
+			notify(notice{userID: parentAuthor, actorID: currentUserID(c), kind: domain.NotifyReply, entryID: &entry.ID, commentID: &commentID})
+		}
+		if parentAuthor != entry.UserID {
+			notify(notice{userID: entry.UserID, actorID: currentUserID(c), kind: domain.NotifyComment, entryID: &entry.ID, commentID: &commentID})
+		}
+		publishEntryEvent(events.CommentCreated, entry.ID, commentID)
+
+		comment, err := loadComment(commentID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusCreated, comment)
+	})
+
+	// Edit the caller's own comment, as long as they can still see the entry it's on
+	auth.PATCH("/comments/:id", func(c *gin.Context) {
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+			return
+		}
+
+		var entryID int
+		err = db.QueryRow(`SELECT entry_id FROM comments WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`,
+			commentID, currentUserID(c)).Scan(&entryID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		if _, ok := loadVisibleEntryByID(c, entryID); !ok {
+			return
+		}
+
+		var in CommentRequest
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body, err := domain.NormalizeComment(in.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := db.Exec(`
+		UPDATE comments SET body = $1, edited_at = NOW()
+		WHERE id = $2 AND user_id = $3 AND deleted_at IS NULL`, body, commentID, currentUserID(c))
+		if err != nil {
+			log.Printf("Failed to update comment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return
+		}
+
+		comment, err := loadComment(commentID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		publishEntryEvent(events.CommentUpdated, comment.EntryID, comment.ID)
+		c.JSON(http.StatusOK, comment)
+	})
+
+	// Delete a comment, either by whoever wrote it or by the owner of the entry it's on.
+	// Its text is removed but it stays in place so replies to it keep their thread
+	auth.DELETE("/comments/:id", func(c *gin.Context) {
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+			return
+		}
+
+		var entryID int
+		err = db.QueryRow(`
+		UPDATE comments SET body = '', deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+			AND (user_id = $2 OR entry_id IN (SELECT id FROM entries WHERE user_id = $2))
+		RETURNING entry_id`, commentID, currentUserID(c)).Scan(&entryID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete comment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+			return
+		}
+
+		publishEntryEvent(events.CommentDeleted, entryID, commentID)
+		c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+	})
+
+	// The reactions that can be left on entries
+	auth.GET("/reactions", func(c *gin.Context) {
+		c.JSON(http.StatusOK, domain.Reactions)
+	})
+
+	// React to an entry the caller can see, reacting twice with the same reaction is a no-op
+	auth.PUT("/entry/:id/reactions/:reaction", func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+		if entry.Status != StatusPublished {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drafts can't be reacted to"})
+			return
+		}
+		if err := domain.ValidateReaction(c.Param("reaction")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := db.Exec(`
+		INSERT INTO reactions (entry_id, user_id, reaction)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, entry.ID, currentUserID(c), c.Param("reaction"))
+		if err != nil {
+			log.Printf("Failed to save reaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reaction"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			notify(notice{userID: entry.UserID, actorID: currentUserID(c), kind: domain.NotifyReaction, entryID: &entry.ID})
+		}
+
+		entryEngagement(c, entry)
+	})
+
+	// Take back a reaction
+	auth.DELETE("/entry/:id/reactions/:reaction", func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+
+		_, err := db.Exec(`DELETE FROM reactions WHERE entry_id = $1 AND user_id = $2 AND reaction = $3`,
+			entry.ID, currentUserID(c), c.Param("reaction"))
+		if err != nil {
+			log.Printf("Failed to remove reaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+			return
+		}
+
+		entryEngagement(c, entry)
+	})
+
+	// Save an entry to the caller's favorites
+	auth.PUT("/entry/:id/favorite", func(c *gin.Context) {
+		entry, ok := loadVisibleEntry(c)
+		if !ok {
+			return
+		}
+		if entry.Status != StatusPublished {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Drafts can't be favorited"})
+			return
+		}
+
+		_, err := db.Exec(`
+		INSERT INTO favorites (user_id, entry_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, currentUserID(c), entry.ID)
+		if err != nil {
+			log.Printf("Failed to save favorite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save favorite"})
+			return
+		}
+
+		entryEngagement(c, entry)
+	})
+
+	// Remove an entry from the caller's favorites, works even if the entry is no longer visible
+	auth.DELETE("/entry/:id/favorite", func(c *gin.Context) {
+		entryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+			return
+		}
+
+		if _, err := db.Exec(`DELETE FROM favorites WHERE user_id = $1 AND entry_id = $2`, currentUserID(c), entryID); err != nil {
+			log.Printf("Failed to remove favorite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove favorite"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"favorited": false})
+	})
+
+	// The caller's favorites, most recently saved first, leaving out entries they can no longer see
+	auth.GET("/me/favorites", func(c *gin.Context) {
+		rows, err := db.Query(`
+		SELECT `+entryColumns+`
+		FROM (
+			SELECT e.*, f.created_at AS favorited_at
+			FROM favorites f
+			JOIN entries e ON e.id = f.entry_id
+			WHERE f.user_id = $1 AND e.status = 'published' AND e.deleted_at IS NULL AND `+visibleToUser+`
+		) entries
+		ORDER BY favorited_at DESC`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// A single use ticket for opening /events with EventSource, tied to the caller's session
+	auth.POST("/events/ticket", func(c *gin.Context) {
+		ticket, hash, err := domain.NewSessionToken()
+		if err != nil {
+			log.Printf("Failed to create stream ticket: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+			return
+		}
+		expiresAt := time.Now().Add(domain.StreamTicketTTL)
+		_, err = db.Exec(`INSERT INTO stream_tickets (token_hash, session_hash, expires_at) VALUES ($1, $2, $3)`,
+			hash, c.GetString("sessionHash"), expiresAt)
+		if err != nil {
+			log.Printf("Failed to create stream ticket: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"ticket": ticket, "expires_at": expiresAt})
+	})
+
+	// Live updates for the caller as Server-Sent Events, each event's data is a JSON events.Event
+	r.GET("/events", requireStreamAuth, func(c *gin.Context) {
+		updates, cancel := hub.Subscribe(currentUserID(c))
+		defer cancel()
+
+		// The session is only checked when the stream opens, it's checked again on every heartbeat
+		// so logging out or resetting the password ends streams that are already open
+		tokenHash := c.GetString("sessionHash")
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		// Stop proxies such as nginx from buffering the stream
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		// A comment line now and then keeps idle connections from being closed along the way
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case e := <-updates:
+				c.SSEvent(e.Type, e)
+			case <-heartbeat.C:
+				var active bool
+				err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sessions WHERE token_hash = $1 AND expires_at > NOW())`, tokenHash).
+					Scan(&active)
+				if err != nil {
+					log.Printf("Session lookup failed: %v", err)
+				} else if !active {
+					return false
+				}
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	// The caller's notifications, newest first, ?unread=true for only the unread ones
+	auth.GET("/me/notifications", func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultNotificationLimit)))
+		if err != nil || limit < 1 || limit > maxNotificationLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxNotificationLimit)})
+			return
+		}
+		unreadOnly := c.Query("unread") == "true"
+
+		rows, err := db.Query(`
+		SELECT n.id, n.type, u.id, u.username, n.entry_id, n.comment_id, n.trip_id, n.read_at IS NOT NULL, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		WHERE n.user_id = $1 AND (NOT $2 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $3`, currentUserID(c), unreadOnly, limit)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		notifications := []Notification{}
+		for rows.Next() {
+			var n Notification
+			var actorID sql.NullInt64
+			var actorName sql.NullString
+			if err := rows.Scan(&n.ID, &n.Type, &actorID, &actorName, &n.EntryID, &n.CommentID, &n.TripID, &n.Read, &n.CreatedAt); err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			if actorID.Valid {
+				n.Actor = &Author{ID: int(actorID.Int64), Username: actorName.String}
+			}
+			notifications = append(notifications, n)
+		}
+
+		unread, err := unreadNotifications(currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"unread_count": unread, "notifications": notifications})
+	})
+
+	// Mark one notification as read
+	auth.POST("/me/notifications/:id/read", func(c *gin.Context) {
+		notificationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+			return
+		}
+
+		res, err := db.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND user_id = $2`, notificationID, currentUserID(c))
+		if err != nil {
+			log.Printf("Failed to mark notification read: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification read"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+
+		unread, err := unreadNotifications(currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"unread_count": unread})
+	})
+
+	// Mark every notification as read
+	auth.POST("/me/notifications/read-all", func(c *gin.Context) {
+		_, err := db.Exec(`UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`, currentUserID(c))
+		if err != nil {
+			log.Printf("Failed to mark notifications read: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"unread_count": 0})
+	})
+
+	// Which notification types the caller gets, every type is on unless turned off
+	auth.GET("/me/notification-preferences", func(c *gin.Context) {
+		prefs, err := notificationPreferences(currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, prefs)
+	})
+
+	// Turn notification types on or off, e.g. {"reaction": false}
+	auth.PATCH("/me/notification-preferences", func(c *gin.Context) {
+		var in map[string]bool
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for kind := range in {
+			if err := domain.ValidateNotificationType(kind); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%v: %s", err, kind)})
+				return
+			}
+		}
+
+		userID := currentUserID(c)
+		for kind, enabled := range in {
+			_, err := db.Exec(`
+			INSERT INTO notification_preferences (user_id, type, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled`, userID, kind, enabled)
+			if err != nil {
+				log.Printf("Failed to save notification preferences: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save notification preferences"})
+				return
+			}
+		}
+
+		prefs, err := notificationPreferences(userID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, prefs)
+	})
+
+	// Follow another user, following twice is a no-op
+	auth.POST("/users/:id/follow", func(c *gin.Context) {
+		followee, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if followee == currentUserID(c) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can't follow yourself"})
+			return
+		}
+
+		res, err := db.Exec(`
+		INSERT INTO follows (follower_id, followee_id)
+		SELECT $1::int, id FROM users WHERE id = $2
+		ON CONFLICT DO NOTHING`, currentUserID(c), followee)
+		if err != nil {
+			log.Printf("Failed to follow user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow user"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			var exists bool
+			if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, followee).Scan(&exists); err != nil || !exists {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+		} else {
+			notify(notice{userID: followee, actorID: currentUserID(c), kind: domain.NotifyFollow})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"following": true})
+	})
+
+	// Stop following a user
+	auth.DELETE("/users/:id/follow", func(c *gin.Context) {
+		if _, err := db.Exec(`DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`, currentUserID(c), c.Param("id")); err != nil {
+			log.Printf("Failed to unfollow user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"following": false})
+	})
+
+	// Users the caller follows, and users following the caller
+	listFollows := func(query string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			rows, err := db.Query(query, currentUserID(c))
+			if err != nil {
+				log.Printf("Database query failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+				return
+			}
+			defer rows.Close()
+
+			follows := []Follow{}
+			for rows.Next() {
+				var f Follow
+				if err := rows.Scan(&f.User.ID, &f.User.Username, &f.CreatedAt); err != nil {
+					log.Printf("Failed to scan row: %v", err)
+					continue
+				}
+				follows = append(follows, f)
+			}
+
+			c.JSON(http.StatusOK, follows)
+		}
+	}
+	auth.GET("/me/following", listFollows(`
+	SELECT u.id, u.username, f.created_at
+	FROM follows f
+	JOIN users u ON u.id = f.followee_id
+	WHERE f.follower_id = $1
+	ORDER BY f.created_at DESC`))
+	auth.GET("/me/followers", listFollows(`
+	SELECT u.id, u.username, f.created_at
+	FROM follows f
+	JOIN users u ON u.id = f.follower_id
+	WHERE f.followee_id = $1
+	ORDER BY f.created_at DESC`))
+
+	// Public entries from everyone the caller follows, newest first, ?cursor= continues from next_cursor
+	auth.GET("/feed", func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultFeedLimit)))
+		if err != nil || limit < 1 || limit > maxFeedLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxFeedLimit)})
+			return
+		}
+
+		query := `
+		SELECT ` + entryColumns + `
+		FROM entries
+		WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id = $1)
+			AND visibility = 'public' AND ` + publishedOnly
+		args := []any{currentUserID(c)}
+		if raw := c.Query("cursor"); raw != "" {
+			cursor, err := domain.ParseCursor(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			args = append(args, cursor.CreatedAt, cursor.ID)
+			query += " AND (created_at, id) < ($2, $3)"
+		}
+		// One extra row tells us whether there is another page
+		args = append(args, limit+1)
+		query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			entry, err := scanEntry(rows)
+			if err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+
+		var next *string
+		if len(entries) > limit {
+			entries = entries[:limit]
+			last := entries[limit-1]
+			cursor := domain.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+			next = &cursor
+		}
+		attachEntryDetails(entries)
+
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "next_cursor": next})
+	})
+
+	// Trip invitations waiting for the caller's answer
+	auth.GET("/me/invitations", func(c *gin.Context) {
+		rows, err := db.Query(`
+		SELECT t.id, t.name, u.id, u.username, m.role, m.created_at
+		FROM trip_members m
+		JOIN trips t ON t.id = m.trip_id
+		JOIN users u ON u.id = m.invited_by
+		WHERE m.user_id = $1 AND m.status = 'pending'
+		ORDER BY m.created_at DESC`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer rows.Close()
+
+		invitations := []Invitation{}
+		for rows.Next() {
+			var inv Invitation
+			if err := rows.Scan(&inv.TripID, &inv.TripName, &inv.InvitedBy.ID, &inv.InvitedBy.Username, &inv.Role, &inv.CreatedAt); err != nil {
+				log.Printf("Failed to scan row: %v", err)
+				continue
+			}
+			invitations = append(invitations, inv)
+		}
+
+		c.JSON(http.StatusOK, invitations)
+	})
+
+	// Accept or decline a trip invitation
+	respond := func(status string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			tripID, err := strconv.Atoi(c.Param("tripId"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trip ID"})
+				return
+			}
+
+			var owner int
+			err = db.QueryRow(`
+			UPDATE trip_members m SET status = $1, responded_at = NOW()
+			FROM trips t
+			WHERE t.id = m.trip_id AND m.trip_id = $2 AND m.user_id = $3 AND m.status = 'pending'
+			RETURNING t.user_id`, status, tripID, currentUserID(c)).Scan(&owner)
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to answer invitation: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to answer invitation"})
+				return
+			}
+			if status == domain.MemberAccepted {
+				notify(notice{userID: owner, actorID: currentUserID(c), kind: domain.NotifyTripJoined, tripID: &tripID})
+			}
+
+			c.JSON(http.StatusOK, gin.H{"trip_id": tripID, "status": status})
+		}
+	}
+	auth.POST("/me/invitations/:tripId/accept", respond(domain.MemberAccepted))
+	auth.POST("/me/invitations/:tripId/decline", respond(domain.MemberDeclined))
+
+	// Download the photos of a trip's published entries as a ZIP archive, the same entries the trip view shows
+	auth.GET("/trips/:id/photos.zip", func(c *gin.Context) {
+		trip, ok := loadTrip(c, domain.RoleViewer)
+		if !ok {
+			return
+		}
+
+		photos, err := collectZipPhotos(`
+		SELECT title, photos, `+entryLocalTime+`
+		FROM entries
+		WHERE trip_id = $1 AND `+publishedOnly+`
+		ORDER BY occurred_at`, trip.ID)
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		streamPhotoZip(c, fmt.Sprintf("trip-%d-photos.zip", trip.ID), photos)
+	})
+
+	// Download every photo the caller has posted as a ZIP archive
+	auth.GET("/me/photos.zip", func(c *gin.Context) {
+		photos, err := collectZipPhotos(`
+		SELECT title, photos, `+entryLocalTime+`
+		FROM entries
+		WHERE user_id = $1 AND `+notDeleted+`
+		ORDER BY occurred_at`, currentUserID(c))
+		if err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+
+		streamPhotoZip(c, "travel-journal-photos.zip", photos)
+	})
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Printf("Starting server on :%s", port)
+	if err := r.Run(":" + port); err != nil {
+		log.Fatalf("Failed to run server: %v", err)
+	}
+}
+
+// Helper function to parse PostgreSQL array format
+func parsePostgresArray(s string) []string {
+	if s == "{}" || s == "" {
+		return []string{}
+	}
+
+	// Remove curly braces and split by comma
+	s = strings.Trim(s, "{}")
+	if s == "" {
+		return []string{}
+	}
+
+	parts := strings.Split(s, ",")
+	result := make([]string, len(parts))
+	for i, part := range parts {
+		result[i] = strings.Trim(part, "\"")
+	}
+	return result
+}
+
+// Decode an image on disk and compute its dimensions and blurhash
+func describePhoto(path, url string) (Photo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Photo{}, err
+	}
+	defer f.Close()
+
+	// A small file can declare huge dimensions, check them before decoding allocates the pixels
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return Photo{}, err
+	}
+	if cfg.Width*cfg.Height > maxPhotoPixels {
+		return Photo{}, fmt.Errorf("image is %dx%d, larger than %d pixels", cfg.Width, cfg.Height, maxPhotoPixels)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Photo{}, err
+	}
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	hash, err := imaging.BlurHash(img, 4, 3)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	bounds := img.Bounds()
+	return Photo{URL: url, Width: bounds.Dx(), Height: bounds.Dy(), BlurHash: hash}, nil
+}
+
+// Fill in the data that lives outside the entries table
+func attachEntryDetails(entries []Entry) {
+	attachPhotoDetails(entries)
+	attachTags(entries)
+	attachAuthors(entries)
+	if err := attachCommentCounts(entries); err != nil {
+		log.Printf("Failed to load comment counts: %v", err)
+	}
+	if err := attachReactions(entries); err != nil {
+		log.Printf("Failed to load reactions: %v", err)
+	}
+}
+
+// Fill in Reactions and FavoriteCount with one grouped count each
+func attachReactions(entries []Entry) error {
+	ids := make([]int64, len(entries))
+	byID := make(map[int]int, len(entries))
+	for i := range entries {
+		entries[i].Reactions = map[string]int{}
+		ids[i] = int64(entries[i].ID)
+		byID[entries[i].ID] = i
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+	SELECT entry_id, reaction, COUNT(*)
+	FROM reactions
+	WHERE entry_id = ANY($1)
+	GROUP BY entry_id, reaction`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var entryID, count int
+		var reaction string
+		if err := rows.Scan(&entryID, &reaction, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		if i, ok := byID[entryID]; ok {
+			entries[i].Reactions[reaction] = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`
+	SELECT entry_id, COUNT(*)
+	FROM favorites
+	WHERE entry_id = ANY($1)
+	GROUP BY entry_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entryID, count int
+		if err := rows.Scan(&entryID, &count); err != nil {
+			return err
+		}
+		if i, ok := byID[entryID]; ok {
+			entries[i].FavoriteCount = count
+		}
+	}
+	return rows.Err()
+}
+
+// Respond with an entry's reaction and favorite counts along with what the caller has left on it
+func entryEngagement(c *gin.Context, entry Entry) {
+	entries := []Entry{entry}
+	err := attachReactions(entries)
+
+	mine := []string{}
+	var favorited bool
+	var rows *sql.Rows
+	if err == nil {
+		rows, err = db.Query(`SELECT reaction FROM reactions WHERE entry_id = $1 AND user_id = $2 ORDER BY reaction`, entry.ID, currentUserID(c))
+	}
+	if err == nil {
+		for rows.Next() {
+			var r string
+			if err = rows.Scan(&r); err != nil {
+				break
+			}
+			mine = append(mine, r)
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	if err == nil {
+		err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM favorites WHERE user_id = $1 AND entry_id = $2)`, currentUserID(c), entry.ID).Scan(&favorited)
+	}
+	if err != nil {
+		log.Printf("Database query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entry_id":       entry.ID,
+		"reactions":      entries[0].Reactions,
+		"favorite_count": entries[0].FavoriteCount,
+		"my_reactions":   mine,
+		"favorited":      favorited,
+	})
+}
+
+// Fill in CommentCount, deleted comments don't count
+func attachCommentCounts(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(entries))
+	byID := make(map[int]int, len(entries))
+	for i := range entries {
+		ids[i] = int64(entries[i].ID)
+		byID[entries[i].ID] = i
+	}
+
+	rows, err := db.Query(`
+	SELECT entry_id, COUNT(*)
+	FROM comments
+	WHERE entry_id = ANY($1) AND deleted_at IS NULL
+	GROUP BY entry_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID, count int
+		if err := rows.Scan(&entryID, &count); err != nil {
+			return err
+		}
+		if i, ok := byID[entryID]; ok {
+			entries[i].CommentCount = count
+		}
+	}
+	return rows.Err()
+}
+
+// Fill in each entry's Author with one query
+func attachAuthors(entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = int64(e.UserID)
+	}
+
+	rows, err := db.Query(`SELECT id, username FROM users WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		log.Printf("Failed to load entry authors: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	authors := make(map[int]*Author)
+	for rows.Next() {
+		var a Author
+		if err := rows.Scan(&a.ID, &a.Username); err != nil {
+			log.Printf("Failed to scan author: %v", err)
+			return
+		}
+		authors[a.ID] = &a
+	}
+	for i := range entries {
+		entries[i].Author = authors[entries[i].UserID]
+	}
+}
+
+// Load the tag names for each entry
+func attachTags(entries []Entry) {
+	ids := make([]int64, len(entries))
+	byID := make(map[int]int, len(entries))
+	for i := range entries {
+		entries[i].Tags = []string{}
+		ids[i] = int64(entries[i].ID)
+		byID[entries[i].ID] = i
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	rows, err := db.Query(`
+	SELECT et.entry_id, t.name
+	FROM entry_tags et
+	JOIN tags t ON t.id = et.tag_id
+	WHERE et.entry_id = ANY($1)
+	ORDER BY t.name`, pq.Array(ids))
+	if err != nil {
+		log.Printf("Failed to load tags: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var name string
+		if err := rows.Scan(&entryID, &name); err != nil {
+			log.Printf("Failed to scan tag: %v", err)
+			continue
+		}
+		if i, ok := byID[entryID]; ok {
+			entries[i].Tags = append(entries[i].Tags, name)
+		}
+	}
+}
+
+// Replace an entry's tags with the given (already normalized) names
+func setEntryTags(tx *sql.Tx, entryID int, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_tags WHERE entry_id = $1`, entryID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`, pq.Array(tags)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+	INSERT INTO entry_tags (entry_id, tag_id)
+	SELECT $1::int, id FROM tags WHERE name = ANY($2)`, entryID, pq.Array(tags))
+	return err
+}
+
+// Look up photo metadata for every photo referenced by the given entries
+func attachPhotoDetails(entries []Entry) {
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.Photos...)
+	}
+	if len(urls) == 0 {
+		for i := range entries {
+			entries[i].PhotoDetails = []Photo{}
+		}
+		return
+	}
+
+	rows, err := db.Query(`SELECT url, width, height, blurhash FROM photos WHERE url = ANY($1)`, pq.Array(urls))
+	if err != nil {
+		log.Printf("Failed to load photo metadata: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	byURL := make(map[string]Photo)
+	for rows.Next() {
+		var photo Photo
+		if err := rows.Scan(&photo.URL, &photo.Width, &photo.Height, &photo.BlurHash); err != nil {
+			log.Printf("Failed to scan photo metadata: %v", err)
+			continue
+		}
+		byURL[photo.URL] = photo
+	}
+
+	// Keep the same order as entry.Photos, skipping photos uploaded before metadata existed
+	for i := range entries {
+		entries[i].PhotoDetails = []Photo{}
+		for _, url := range entries[i].Photos {
+			if photo, ok := byURL[url]; ok {
+				entries[i].PhotoDetails = append(entries[i].PhotoDetails, photo)
+			}
+		}
+	}
+}
+
+// Columns selected whenever we load a full entry, in the order scanEntry expects
+const entryColumns = `id, user_id, trip_id, title, content, location, COALESCE(country_code, ''), latitude, longitude, photos, occurred_at, time_zone, status, visibility, version, updated_at, deleted_at, created_at`
+
+// Condition leaving out entries in the trash
+const notDeleted = `deleted_at IS NULL`
+
+// Condition on entries e that user $1 may read by ID: their own, public, or on a trip they're part of
+const visibleToUser = `(e.user_id = $1 OR e.visibility = 'public' OR e.trip_id IN (
+	SELECT id FROM trips WHERE user_id = $1
+	UNION SELECT trip_id FROM trip_members WHERE user_id = $1 AND status = 'accepted'))`
+
+// Condition limiting a query to published entries that aren't in the trash
+const publishedOnly = `status = 'published' AND ` + notDeleted
+
+// The same two conditions for queries that join entries as e
+const (
+	entryNotDeleted    = `e.deleted_at IS NULL`
+	entryPublishedOnly = `e.status = 'published' AND ` + entryNotDeleted
+)
+
+// The wall clock time an entry happened at, where it happened, used for anything that groups by calendar day
+const entryLocalTime = `(occurred_at AT TIME ZONE time_zone)`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanWithExtra lets scanEntry read rows that select extra columns after entryColumns
+type scanWithExtra struct {
+	row   rowScanner
+	extra []any
+}
+
+func (s scanWithExtra) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// Columns copied from entries (aliased e) into entry_revisions, in insert order, $1 is the author
+const revisionInsert = `INSERT INTO entry_revisions (entry_id, rev, author_id, title, content, location, country_code,
+	latitude, longitude, photos, tags, trip_id, occurred_at, time_zone, status)`
+
+// The snapshot of entries e that follows entry_id, rev and author_id in revisionInsert
+const revisionSnapshot = `e.title, e.content, e.location, e.country_code, e.latitude, e.longitude, e.photos,
+	ARRAY(SELECT t.name FROM entry_tags et JOIN tags t ON t.id = et.tag_id WHERE et.entry_id = e.id ORDER BY t.name),
+	e.trip_id, e.occurred_at, e.time_zone, e.status`
+
+// Append the entry's current state to its history, call after every change inside the same transaction
+func recordRevision(tx *sql.Tx, entryID, authorID int) error {
+	_, err := tx.Exec(revisionInsert+`
+	SELECT e.id, e.version, $2::int, `+revisionSnapshot+`
+	FROM entries e
+	WHERE e.id = $1`, entryID, authorID)
+	return err
+}
+
+const revisionColumns = `id, entry_id, rev, COALESCE(author_id, 0), title, content, COALESCE(location, ''), COALESCE(country_code, ''),
+	latitude, longitude, COALESCE(photos, '{}'), tags, trip_id, occurred_at, time_zone, status, created_at`
+
+func scanRevision(row rowScanner) (Revision, error) {
+	var r Revision
+	err := row.Scan(&r.ID, &r.EntryID, &r.Rev, &r.AuthorID, &r.Title, &r.Content, &r.Location, &r.CountryCode,
+		&r.Latitude, &r.Longitude, pq.Array(&r.Photos), pq.Array(&r.Tags), &r.TripID, &r.OccurredAt, &r.TimeZone, &r.Status, &r.CreatedAt)
+	if r.Photos == nil {
+		r.Photos = []string{}
+	}
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+	return r, err
+}
+
+func loadRevision(entryID, rev int) (Revision, error) {
+	return scanRevision(db.QueryRow(`SELECT `+revisionColumns+` FROM entry_revisions WHERE entry_id = $1 AND rev = $2`, entryID, rev))
+}
+
+func entryRevisions(entryID int) ([]Revision, error) {
+	rows, err := db.Query(`SELECT `+revisionColumns+` FROM entry_revisions WHERE entry_id = $1 ORDER BY rev DESC`, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Parse the :id param and load that entry if the caller may read it, writing an error response if not
+func loadVisibleEntry(c *gin.Context) (Entry, bool) {
+	entryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+		return Entry{}, false
+	}
+	return loadVisibleEntryByID(c, entryID)
+}
+
+// Load an entry if the caller may read it, writing an error response if not.
+// Drafts are only visible to their author, link-only and private entries also to members of the entry's trip
+func loadVisibleEntryByID(c *gin.Context, entryID int) (Entry, bool) {
+	userID := currentUserID(c)
+	entry, err := loadEntry(entryID)
+	if err == nil && entry.Status == StatusDraft && entry.UserID != userID {
+		err = sql.ErrNoRows
+	}
+	if err == nil && entry.DeletedAt != nil {
+		err = sql.ErrNoRows
+	}
+	// Link-only entries are read through /s/:token, not by ID
+	if err == nil && entry.Visibility != domain.VisibilityPublic && entry.UserID != userID {
+		role := ""
+		if entry.TripID != nil && userID != 0 && entry.Status == StatusPublished {
+			role, err = tripRole(*entry.TripID, userID)
+		}
+		if err == nil && role == "" {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		} else {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		}
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Parse the :id param as an entry the caller owns, writing an error response if it isn't
+func ownEntryID(c *gin.Context) (int, bool) {
+	entryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+		return 0, false
+	}
+
+	var owner int
+	err = db.QueryRow(`SELECT user_id FROM entries WHERE id = $1 AND deleted_at IS NULL`, entryID).Scan(&owner)
+	if err != nil || owner != currentUserID(c) {
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Database query failed: %v", err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return 0, false
+	}
+	return entryID, true
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Load a single entry by ID
+func loadEntry(id int) (Entry, error) {
+	return scanEntry(db.QueryRow(`SELECT `+entryColumns+` FROM entries WHERE id = $1`, id))
+}
+
+// Scan one row selected with entryColumns into an Entry
+func scanEntry(row rowScanner) (Entry, error) {
+	var entry Entry
+	var tripID sql.NullInt64
+	var lat, lng sql.NullFloat64
+	var photosStr string
+
+	err := row.Scan(
+		&entry.ID,
+		&entry.UserID,
+		&tripID,
+		&entry.Title,
+		&entry.Content,
+		&entry.Location,
+		&entry.CountryCode,
+		&lat,
+		&lng,
+		&photosStr,
+		&entry.OccurredAt,
+		&entry.TimeZone,
+		&entry.Status,
+		&entry.Visibility,
+		&entry.Version,
+		&entry.UpdatedAt,
+		&entry.DeletedAt,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	loc, err := loadLocation(entry.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	entry.OccurredAtLocal = entry.OccurredAt.In(loc).Format(time.RFC3339)
+	entry.OccurredAt = entry.OccurredAt.UTC()
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	entry.UpdatedAt = entry.UpdatedAt.UTC()
+	if entry.DeletedAt != nil {
+		deletedAt := entry.DeletedAt.UTC()
+		entry.DeletedAt = &deletedAt
+	}
+
+	if tripID.Valid {
+		id := int(tripID.Int64)
+		entry.TripID = &id
+	}
+	if lat.Valid && lng.Valid {
+		entry.Latitude, entry.Longitude = &lat.Float64, &lng.Float64
+	}
+	// Parse PostgreSQL array format to Go slice
+	entry.Photos = parsePostgresArray(photosStr)
+	return entry, nil
+}
+
+// Hard-delete the entries matching cond and release photos nothing else refers to any more,
+// revisions and tags go with the entry
+func purgeEntries(cond string, args ...any) (int, error) {
+	rows, err := db.Query(`DELETE FROM entries WHERE `+cond+` RETURNING COALESCE(photos, '{}')`, args...)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	var urls []string
+	for rows.Next() {
+		var photosStr string
+		if err := rows.Scan(&photosStr); err != nil {
+			rows.Close()
+			return n, err
+		}
+		urls = append(urls, parsePostgresArray(photosStr)...)
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	releasePhotos(urls)
+	return n, nil
+}
+
+// Remove the file and metadata of each photo that no entry or revision uses any more
+func releasePhotos(urls []string) {
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+
+		var used bool
+		err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM entries WHERE $1 = ANY(photos))
+			OR EXISTS (SELECT 1 FROM entry_revisions WHERE $1 = ANY(photos))`, url).Scan(&used)
+		if err != nil {
+			log.Printf("Failed to check photo %s: %v", url, err)
+			continue
+		}
+		if used {
+			continue
 		}
 
-		c.JSON(http.StatusCreated, gin.H{
-			"message": "Entry created successfully",
-			"id":      entryID,
-		})
-	})
+		if _, err := db.Exec(`DELETE FROM photos WHERE url = $1`, url); err != nil {
+			log.Printf("Failed to delete photo metadata %s: %v", url, err)
+		}
+		if path, ok := uploadPath(url); ok {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to delete photo %s: %v", path, err)
+			}
+		}
+	}
+}
 
-	// Get entries for a user
-	r.GET("/entries/:userId", func(c *gin.Context) {
-		userIDStr := c.Param("userId")
-		userID, err := strconv.Atoi(userIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
+// Columns for scanComment, from comments c joined with users u
+const commentColumns = `c.id, c.entry_id, c.parent_id, u.id, u.username, c.body, c.deleted_at IS NOT NULL, c.edited_at, c.created_at`
+
+func scanComment(row rowScanner) (Comment, error) {
+	var cm Comment
+	err := row.Scan(&cm.ID, &cm.EntryID, &cm.ParentID, &cm.Author.ID, &cm.Author.Username, &cm.Body, &cm.Deleted, &cm.EditedAt, &cm.CreatedAt)
+	cm.Replies = []*Comment{}
+	return cm, err
+}
+
+func loadComment(id int) (Comment, error) {
+	return scanComment(db.QueryRow(`
+	SELECT `+commentColumns+`
+	FROM comments c
+	JOIN users u ON u.id = c.user_id
+	WHERE c.id = $1`, id))
+}
+
+// Nest comments (oldest first) under their parents and return the top level ones
+func commentThreads(comments []Comment) []*Comment {
+	byID := make(map[int]*Comment, len(comments))
+	for i := range comments {
+		byID[comments[i].ID] = &comments[i]
+	}
+
+	roots := []*Comment{}
+	for i := range comments {
+		cm := &comments[i]
+		if cm.ParentID != nil {
+			if parent, ok := byID[*cm.ParentID]; ok {
+				parent.Replies = append(parent.Replies, cm)
+				continue
+			}
 		}
+		roots = append(roots, cm)
+	}
+	return roots
+}
 
-		query := `
-		SELECT id, user_id, title, content, location, photos, created_at 
-		FROM entries 
-		WHERE user_id = $1 
-		ORDER BY created_at DESC`
+// Record a notification, nothing is sent for things people do to themselves, for types the
+// recipient has turned off or again within notificationDedupeWindow of the same one.
+// Failures are only logged, they never fail the action that caused them
+func notify(n notice) {
+	if n.userID == 0 || n.userID == n.actorID {
+		return
+	}
+	var id int
+	err := db.QueryRow(`
+	INSERT INTO notifications (user_id, actor_id, type, entry_id, comment_id, trip_id)
+	SELECT $1::int, $2::int, $3::varchar, $4::int, $5::int, $6::int
+	WHERE NOT EXISTS (
+		SELECT 1 FROM notification_preferences
+		WHERE user_id = $1 AND type = $3 AND NOT enabled
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM notifications
+		WHERE user_id = $1 AND actor_id = $2 AND type = $3
+			AND entry_id IS NOT DISTINCT FROM $4 AND comment_id IS NOT DISTINCT FROM $5
+			AND trip_id IS NOT DISTINCT FROM $6 AND created_at > $7
+	)
+	RETURNING id`, n.userID, n.actorID, n.kind, n.entryID, n.commentID, n.tripID, time.Now().Add(-notificationDedupeWindow)).Scan(&id)
+	switch {
+	case err == nil:
+		hub.Publish(events.Event{Type: events.Notification, NotificationID: id, Recipients: []int{n.userID}})
+	case err != sql.ErrNoRows:
+		log.Printf("Failed to send %s notification to user %d: %v", n.kind, n.userID, err)
+	}
+}
 
-		rows, err := db.Query(query, userID)
-		if err != nil {
-			log.Printf("Database query failed: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+// Tell the entry's author and everyone on its trip that the entry (or a comment on it, if commentID is set) changed.
+// A draft is only the author's until it's published, so the trip hears nothing about it
+func publishEntryEvent(kind string, entryID, commentID int) {
+	rows, err := db.Query(`
+	SELECT e.user_id, COALESCE(e.trip_id, 0) FROM entries e WHERE e.id = $1
+	UNION SELECT t.user_id, t.id FROM entries e JOIN trips t ON t.id = e.trip_id
+		WHERE e.id = $1 AND e.status = 'published'
+	UNION SELECT m.user_id, m.trip_id FROM entries e JOIN trip_members m ON m.trip_id = e.trip_id AND m.status = 'accepted'
+		WHERE e.id = $1 AND e.status = 'published'`, entryID)
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", kind, err)
+		return
+	}
+	defer rows.Close()
+
+	e := events.Event{Type: kind, EntryID: entryID, CommentID: commentID}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID, &e.TripID); err != nil {
+			log.Printf("Failed to publish %s event: %v", kind, err)
 			return
 		}
-		defer rows.Close()
+		e.Recipients = append(e.Recipients, userID)
+	}
+	hub.Publish(e)
+}
 
-		var entries []Entry
-		for rows.Next() {
-			var entry Entry
-			var photosStr string
-
-			err := rows.Scan(
-				&entry.ID,
-				&entry.UserID,
-				&entry.Title,
-				&entry.Content,
-				&entry.Location,
-				&photosStr,
-				&entry.CreatedAt,
-			)
-			if err != nil {
-				log.Printf("Failed to scan row: %v", err)
-				continue
-			}
+func unreadNotifications(userID int) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
 
-			// Parse PostgreSQL array format to Go slice
-			entry.Photos = parsePostgresArray(photosStr)
-			entries = append(entries, entry)
+// Every notification type with whether the user gets it
+func notificationPreferences(userID int) (map[string]bool, error) {
+	prefs := make(map[string]bool, len(domain.NotificationTypes))
+	for _, kind := range domain.NotificationTypes {
+		prefs[kind] = true
+	}
+
+	rows, err := db.Query(`SELECT type, enabled FROM notification_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var enabled bool
+		if err := rows.Scan(&kind, &enabled); err != nil {
+			return nil, err
+		}
+		if _, ok := prefs[kind]; ok {
+			prefs[kind] = enabled
 		}
+	}
+	return prefs, rows.Err()
+}
 
-		c.JSON(http.StatusOK, entries)
-	})
+// Strip an entry down to what a share link may show
+func sharedEntry(e Entry) SharedEntry {
+	return SharedEntry{
+		Title:           e.Title,
+		Content:         e.Content,
+		Location:        e.Location,
+		CountryCode:     e.CountryCode,
+		Latitude:        e.Latitude,
+		Longitude:       e.Longitude,
+		Photos:          e.Photos,
+		PhotoDetails:    e.PhotoDetails,
+		Tags:            e.Tags,
+		OccurredAt:      e.OccurredAt,
+		OccurredAtLocal: e.OccurredAtLocal,
+		TimeZone:        e.TimeZone,
+	}
+}
 
-	// Get single entry
-	r.GET("/entry/:id", func(c *gin.Context) {
-		entryIDStr := c.Param("id")
-		entryID, err := strconv.Atoi(entryIDStr)
+// Latitude and longitude are optional but must come as a valid pair
+func validateCoordinates(lat, lng *float64) error {
+	if lat == nil && lng == nil {
+		return nil
+	}
+	if lat == nil || lng == nil {
+		return errors.New("Latitude and longitude must be provided together")
+	}
+	return geo.Validate(*lat, *lng)
+}
+
+// Load a user's entries whose coordinates fall inside box
+func entriesInBox(userID int, box geo.Box) ([]Entry, error) {
+	// Split the longitude test when the box wraps so both halves can use the index
+	lngCond := `longitude BETWEEN $4 AND $5`
+	if box.MinLng > box.MaxLng {
+		lngCond = `(longitude >= $4 OR longitude <= $5)`
+	}
+
+	rows, err := db.Query(`
+	SELECT `+entryColumns+`
+	FROM entries
+	WHERE user_id = $1 AND `+publishedOnly+` AND latitude BETWEEN $2 AND $3 AND `+lngCond+`
+	ORDER BY occurred_at DESC`, userID, box.MinLat, box.MaxLat, box.MinLng, box.MaxLng)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
-			return
+			return nil, err
 		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
 
-		query := `
-		SELECT id, user_id, title, content, location, photos, created_at 
-		FROM entries 
-		WHERE id = $1`
+// Turn located entries into export features, photo URLs are made absolute (against PUBLIC_URL,
+// never the request's Host) so desktop GIS tools can load them
+func entryFeatures(entries []Entry) []geo.Feature {
+	features := make([]geo.Feature, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Latitude == nil {
+			continue
+		}
+		feature := geo.Feature{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Date:    entry.OccurredAt,
+			Snippet: snippet(entry.Content, 200),
+			Point:   geo.Point{Lat: *entry.Latitude, Lng: *entry.Longitude},
+		}
+		if len(entry.Photos) > 0 {
+			feature.PhotoURL = publicURL + entry.Photos[0]
+		}
+		features = append(features, feature)
+	}
+	return features
+}
 
-		var entry Entry
-		var photosStr string
+// Shorten text to at most n characters, cutting at a word boundary when possible
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndex(cut, " "); i > n/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
 
-		err = db.QueryRow(query, entryID).Scan(
-			&entry.ID,
-			&entry.UserID,
-			&entry.Title,
-			&entry.Content,
-			&entry.Location,
-			&photosStr,
-			&entry.CreatedAt,
-		)
+// Parse a float query parameter, writing a 400 if it is missing (and has no default) or malformed
+func floatQuery(c *gin.Context, name string, def *float64) (float64, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		if def != nil {
+			return *def, true
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " is required"})
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return 0, false
+	}
+	return v, true
+}
 
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
-			} else {
-				log.Printf("Database query failed: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
-			}
-			return
+// Load the trip named by the :id param, writing an error response unless the caller owns it
+func loadTrip(c *gin.Context, need string) (Trip, bool) {
+	tripID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trip ID"})
+		return Trip{}, false
+	}
+
+	var trip Trip
+	err = db.QueryRow(`
+	SELECT id, user_id, name, description, created_at
+	FROM trips
+	WHERE id = $1`, tripID).Scan(&trip.ID, &trip.UserID, &trip.Name, &trip.Description, &trip.CreatedAt)
+	if err == nil {
+		trip.Role, err = tripRole(trip.ID, currentUserID(c))
+	}
+	if err != nil || trip.Role == "" {
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Database query failed: %v", err)
 		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return Trip{}, false
+	}
+	if !domain.HasRole(trip.Role, need) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do that on this trip"})
+		return Trip{}, false
+	}
+	return trip, true
+}
 
-		entry.Photos = parsePostgresArray(photosStr)
-		c.JSON(http.StatusOK, entry)
-	})
+// A user's role on a trip: owner, editor or viewer once they've accepted an invitation, "" otherwise
+func tripRole(tripID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`
+	SELECT CASE WHEN t.user_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END
+	FROM trips t
+	LEFT JOIN trip_members m ON m.trip_id = t.id AND m.user_id = $2 AND m.status = 'accepted'
+	WHERE t.id = $1`, tripID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
 
-	// Register endpoint
-	r.POST("/register", func(c *gin.Context) {
-		var in RegisterRequest
-		if err := c.ShouldBindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+// The time zone to use for the caller: ?tz= if given, otherwise their profile setting
+func userLocation(c *gin.Context, userID int) (*time.Location, bool) {
+	name := c.Query("tz")
+	if name == "" {
+		if err := db.QueryRow(`SELECT time_zone FROM users WHERE id = $1`, userID).Scan(&name); err != nil {
+			log.Printf("Database query failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return nil, false
 		}
+	}
 
-		// Hash the password
-		hashedPassword, err := HashPassword(in.Password)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-			return
+	loc, err := loadLocation(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+		return nil, false
+	}
+	return loc, true
+}
+
+var locationCache sync.Map
+
+// time.LoadLocation with a cache, entries are scanned often and parsing zoneinfo each time adds up
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, loc)
+	return loc, nil
+}
+
+// Parse an entry's occurred_at, accepting RFC3339 or a wall clock time in loc
+func parseOccurredAt(raw string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t, nil
 		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", raw)
+}
 
-		query := `
-		INSERT INTO users (username, email, password_hash) 
-		VALUES ($1, $2, $3) 
-		RETURNING id`
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
 
-		var userID int
-		err = db.QueryRow(query, in.Username, in.Email, hashedPassword).Scan(&userID)
-		if err != nil {
-			if strings.Contains(err.Error(), "duplicate key") {
-				c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-			} else {
-				log.Printf("Failed to register user: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Registration failed"})
-			}
-			return
+// Compute a user's statistics, each figure is a single SQL aggregate over entries (and trips/tracks)
+func userStats(userID int, includeDrafts bool) (Stats, error) {
+	// Drafts are left out unless asked for, the trash always is
+	visible, visibleJoined := " AND "+publishedOnly, " AND "+entryPublishedOnly
+	if includeDrafts {
+		visible, visibleJoined = " AND "+notDeleted, " AND "+entryNotDeleted
+	}
+
+	stats := Stats{
+		EntriesPerMonth: []MonthCount{},
+		Countries:       []NamedCount{},
+		Cities:          []NamedCount{},
+	}
+
+	err := db.QueryRow(`
+	SELECT COUNT(*), COALESCE(SUM(COALESCE(cardinality(photos), 0)), 0)
+	FROM entries
+	WHERE user_id = $1`+visible, userID).Scan(&stats.TotalEntries, &stats.TotalPhotos)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := db.Query(`
+	SELECT to_char(date_trunc('month', `+entryLocalTime+`), 'YYYY-MM') AS month, COUNT(*)
+	FROM entries
+	WHERE user_id = $1`+visible+`
+	GROUP BY month
+	ORDER BY month`, userID)
+	if err != nil {
+		return stats, err
+	}
+	for rows.Next() {
+		var m MonthCount
+		if err := rows.Scan(&m.Month, &m.Count); err != nil {
+			rows.Close()
+			return stats, err
 		}
+		stats.EntriesPerMonth = append(stats.EntriesPerMonth, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
 
-		c.JSON(http.StatusCreated, gin.H{
-			"message": "User registered successfully",
-			"user_id": userID,
-		})
-	})
+	if stats.Countries, err = namedCounts(`
+	SELECT country_code, COUNT(*)
+	FROM entries
+	WHERE user_id = $1 AND country_code IS NOT NULL`+visible+`
+	GROUP BY country_code
+	ORDER BY COUNT(*) DESC, country_code`, userID); err != nil {
+		return stats, err
+	}
+	stats.CountriesVisited = len(stats.Countries)
 
-	// Login endpoint
-	r.POST("/login", func(c *gin.Context) {
-		var in LoginRequest
-		if err := c.ShouldBindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+	// Cities are the places resolved from coordinates, typed locations are free text and aren't counted
+	if stats.Cities, err = namedCounts(`
+	SELECT place, COUNT(*)
+	FROM entries
+	WHERE user_id = $1 AND place IS NOT NULL`+visible+`
+	GROUP BY place
+	ORDER BY COUNT(*) DESC, place`, userID); err != nil {
+		return stats, err
+	}
+	stats.CitiesVisited = len(stats.Cities)
+
+	var trip TripSpan
+	err = db.QueryRow(`
+	SELECT t.id, t.name, MIN(e.occurred_at AT TIME ZONE e.time_zone)::date, MAX(e.occurred_at AT TIME ZONE e.time_zone)::date,
+		MAX(e.occurred_at AT TIME ZONE e.time_zone)::date - MIN(e.occurred_at AT TIME ZONE e.time_zone)::date + 1 AS days
+	FROM trips t
+	JOIN entries e ON e.trip_id = t.id
+	WHERE t.user_id = $1`+visibleJoined+`
+	GROUP BY t.id, t.name
+	ORDER BY days DESC, t.id
+	LIMIT 1`, userID).Scan(&trip.ID, &trip.Name, &trip.StartDate, &trip.EndDate, &trip.Days)
+	switch {
+	case err == nil:
+		stats.LongestTrip = &trip
+	case err != sql.ErrNoRows:
+		return stats, err
+	}
+
+	// Gaps and islands: consecutive days share the same (day - row number)
+	var current bool
+	err = db.QueryRow(`
+	WITH days AS (
+		SELECT DISTINCT `+entryLocalTime+`::date AS day FROM entries WHERE user_id = $1`+visible+`
+	), streaks AS (
+		SELECT MAX(day) AS last_day, COUNT(*) AS length
+		FROM (SELECT day, day - (ROW_NUMBER() OVER (ORDER BY day))::int AS grp FROM days) d
+		GROUP BY grp
+	)
+	SELECT
+		COALESCE((SELECT length FROM streaks ORDER BY last_day DESC LIMIT 1), 0),
+		COALESCE((SELECT MAX(last_day) FROM streaks) >=
+			(SELECT (NOW() AT TIME ZONE time_zone)::date - 1 FROM users WHERE id = $1), false),
+		COALESCE((SELECT MAX(length) FROM streaks), 0)`, userID).Scan(
+		&stats.CurrentStreakDays, &current, &stats.LongestStreakDays)
+	if err != nil {
+		return stats, err
+	}
+	// A streak is only current if the last entry was today or yesterday
+	if !current {
+		stats.CurrentStreakDays = 0
+	}
+
+	// The same haversine as geo.DistanceKM, with its earth radius
+	err = db.QueryRow(`
+	SELECT COALESCE(SUM(2 * $2::float8 * ASIN(LEAST(1, SQRT(
+		POWER(SIN(RADIANS(latitude - prev_lat) / 2), 2) +
+		COS(RADIANS(prev_lat)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - prev_lng) / 2), 2)
+	)))), 0)
+	FROM (
+		SELECT latitude, longitude,
+			LAG(latitude) OVER (ORDER BY occurred_at) AS prev_lat,
+			LAG(longitude) OVER (ORDER BY occurred_at) AS prev_lng
+		FROM entries
+		WHERE user_id = $1 AND latitude IS NOT NULL`+visible+`
+	) hops
+	WHERE prev_lat IS NOT NULL`, userID, geo.EarthRadiusKM).Scan(&stats.DistanceKM)
+	if err != nil {
+		return stats, err
+	}
+
+	err = db.QueryRow(`
+	SELECT COALESCE(SUM(tr.distance_km), 0)
+	FROM tracks tr
+	JOIN trips t ON t.id = tr.trip_id
+	WHERE t.user_id = $1`, userID).Scan(&stats.TrackDistanceKM)
+	return stats, err
+}
+
+// Run a (name, count) query for the stats endpoint
+func namedCounts(query string, args ...any) ([]NamedCount, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []NamedCount{}
+	for rows.Next() {
+		var n NamedCount
+		if err := rows.Scan(&n.Name, &n.Count); err != nil {
+			return nil, err
 		}
+		counts = append(counts, n)
+	}
+	return counts, rows.Err()
+}
 
-		query := `SELECT id, username, password_hash FROM users WHERE email = $1`
+// GPX uploads larger than this are rejected
+const maxGPXBytes = 20 << 20
 
-		var userID int
-		var username, passwordHash string
-		err := db.QueryRow(query, in.Email).Scan(&userID, &username, &passwordHash)
+// Points closer than this to the simplified line are dropped when storing a track
+const trackToleranceM = 10
 
+// Load every track attached to a trip, oldest first
+func tripTracks(tripID int) ([]Track, error) {
+	rows, err := db.Query(`
+	SELECT id, trip_id, name, distance_km, elevation_gain_m, duration_seconds, started_at, ended_at, polylines, waypoints, created_at
+	FROM tracks
+	WHERE trip_id = $1
+	ORDER BY COALESCE(started_at, created_at)`, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []Track{}
+	for rows.Next() {
+		var track Track
+		var waypoints []byte
+		err := rows.Scan(&track.ID, &track.TripID, &track.Name, &track.DistanceKM, &track.ElevationGainM,
+			&track.DurationSeconds, &track.StartedAt, &track.EndedAt, pq.Array(&track.Polylines), &waypoints, &track.CreatedAt)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
-			} else {
-				log.Printf("Database query failed: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return nil, err
+		}
+		if err := json.Unmarshal(waypoints, &track.Waypoints); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// zipPhoto is one uploaded file and the name it gets inside an archive
+type zipPhoto struct {
+	name     string
+	path     string
+	modified time.Time
+}
+
+// Run a query returning (title, photos, local time) rows and name each photo by entry date and title
+func collectZipPhotos(query string, args ...any) ([]zipPhoto, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []zipPhoto
+	used := make(map[string]bool)
+	for rows.Next() {
+		var title, photosStr string
+		var localTime time.Time
+		if err := rows.Scan(&title, &photosStr, &localTime); err != nil {
+			return nil, err
+		}
+
+		base := localTime.Format("2006-01-02") + " " + sanitizeFilename(title)
+		for i, url := range parsePostgresArray(photosStr) {
+			path, ok := uploadPath(url)
+			if !ok {
+				continue
 			}
-			return
+
+			// Number photos within an entry and avoid clashes between same-day entries
+			stem := base
+			if i > 0 {
+				stem = fmt.Sprintf("%s %d", base, i+1)
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			name := stem + ext
+			for n := 2; used[name]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+			}
+			used[name] = true
+
+			photos = append(photos, zipPhoto{name: name, path: path, modified: localTime})
 		}
+	}
+	return photos, rows.Err()
+}
 
-		// Verify password
-		if !CheckPasswordHash(in.Password, passwordHash) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
-			return
+// Write photos to the response as a ZIP, copying each file straight from disk
+func streamPhotoZip(c *gin.Context, filename string, photos []zipPhoto) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	for _, photo := range photos {
+		f, err := os.Open(photo.path)
+		if err != nil {
+			log.Printf("Skipping missing photo %s: %v", photo.path, err)
+			continue
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message":  "Login successful",
-			"user_id":  userID,
-			"username": username,
+		// Photos are already compressed so store them as-is
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     photo.name,
+			Method:   zip.Store,
+			Modified: photo.modified,
 		})
-	})
+		if err == nil {
+			_, err = io.Copy(w, f)
+		}
+		f.Close()
+		if err != nil {
+			// The client has most likely gone away, nothing more we can send
+			log.Printf("Failed to stream photo archive: %v", err)
+			return
+		}
+		c.Writer.Flush()
+	}
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to finish photo archive: %v", err)
 	}
-	log.Printf("Starting server on :%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+}
+
+// Map a /uploads/... URL to the file on disk
+func uploadPath(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, "/uploads/")
+	if !ok || name == "" || name != filepath.Base(name) {
+		return "", false
 	}
+	return filepath.Join("./public/uploads", name), true
 }
 
-// Helper function to parse PostgreSQL array format
-func parsePostgresArray(s string) []string {
-	if s == "{}" || s == "" {
-		return []string{}
+// Make a title safe to use as a file name
+func sanitizeFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|':
+			return '_'
+		case r < 32:
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if len([]rune(s)) > 80 {
+		s = string([]rune(s)[:80])
 	}
-	
-	// Remove curly braces and split by comma
-	s = strings.Trim(s, "{}")
 	if s == "" {
-		return []string{}
-	}
-	
-	parts := strings.Split(s, ",")
-	result := make([]string, len(parts))
-	for i, part := range parts {
-		result[i] = strings.Trim(part, "\"")
+		s = "untitled"
 	}
-	return result
-}
\ No newline at end of file
+	return s
+}
@@ -0,0 +1,191 @@
+/*
+this file computes word level diffs between two versions of an entry
+text is split into words and the whitespace between them, then compared with
+Myers' algorithm so the result is the smallest set of inserted and deleted words
+Myers needs memory growing with the square of the number of edits, so past a limit
+(two long, unrelated versions) the diff is just the old text removed and the new text added
+*/
+
+package diff
+
+import (
+	"unicode"
+)
+
+// Op types
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// limits on the part that differs once the common prefix and suffix are skipped, the search
+// keeps O(edits²) ints and takes O(tokens·edits) time
+const (
+	maxTokens = 50000
+	maxEdits  = 1000
+)
+
+// Op is a run of text that is unchanged, added or removed
+type Op struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Words diffs a against b, joining the Text of every non-insert op gives a
+// and joining every non-delete op gives b
+func Words(a, b string) []Op {
+	x, y := tokenize(a), tokenize(b)
+
+	// skip the common prefix and suffix, most edits only touch a small part of an entry
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	for _, t := range x[:prefix] {
+		ops = appendOp(ops, Equal, t)
+	}
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+	middle, ok := myers(mx, my)
+	if !ok {
+		middle = replace(mx, my)
+	}
+	for _, op := range middle {
+		ops = appendOp(ops, op.Type, op.Text)
+	}
+	for _, t := range x[len(x)-suffix:] {
+		ops = appendOp(ops, Equal, t)
+	}
+	return ops
+}
+
+// appendOp merges runs of the same type so the output stays compact
+func appendOp(ops []Op, typ, text string) []Op {
+	if n := len(ops); n > 0 && ops[n-1].Type == typ {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Type: typ, Text: text})
+}
+
+// tokenize splits text into alternating runs of whitespace and non-whitespace
+func tokenize(s string) []string {
+	var tokens []string
+	start := 0
+	var inSpace bool
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > start && space != inSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// replace is the fallback script, all of x deleted then all of y inserted
+func replace(x, y []string) []Op {
+	var ops []Op
+	for _, t := range x {
+		ops = append(ops, Op{Type: Delete, Text: t})
+	}
+	for _, t := range y {
+		ops = append(ops, Op{Type: Insert, Text: t})
+	}
+	return ops
+}
+
+// myers returns the edit script turning x into y, see
+// "An O(ND) Difference Algorithm and Its Variations" (Myers, 1986).
+// It gives up (ok false) when the inputs or the number of edits go past the limits above
+func myers(x, y []string) (ops []Op, ok bool) {
+	n, m := len(x), len(y)
+	max := n + m
+	if max == 0 {
+		return nil, true
+	}
+	if max > maxTokens {
+		return nil, false
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v as it was before round d, only the diagonals -d-1..d+1 that round
+	// d and the walk back read, trace[d][k+d+1] is v[k]
+	var trace [][]int
+
+	found := false
+search:
+	for d := 0; d <= max && d <= maxEdits; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				i = v[offset+k+1] // move down: insert from y
+			} else {
+				i = v[offset+k-1] + 1 // move right: delete from x
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// walk the trace backwards to recover the path, trace[d] holds v as it was before round d
+	var reversed []Op
+	i, j := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := d + 1 // index of diagonal 0 in v
+		k := i - j
+		var prevK int
+		if k == -d || (k != d && v[at+k-1] < v[at+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevI := v[at+prevK]
+		prevJ := prevI - prevK
+
+		for i > prevI && j > prevJ {
+			i--
+			j--
+			reversed = append(reversed, Op{Type: Equal, Text: x[i]})
+		}
+		if d > 0 {
+			if i == prevI {
+				j--
+				reversed = append(reversed, Op{Type: Insert, Text: y[j]})
+			} else {
+				i--
+				reversed = append(reversed, Op{Type: Delete, Text: x[i]})
+			}
+		}
+	}
+
+	ops = make([]Op, len(reversed))
+	for idx, op := range reversed {
+		ops[len(reversed)-1-idx] = op
+	}
+	return ops, true
+}
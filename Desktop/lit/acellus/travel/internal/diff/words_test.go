@@ -0,0 +1,74 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rebuild joins the ops that make up one side of the diff
+func rebuild(ops []Op, skip string) string {
+	var b strings.Builder
+	for _, op := range ops {
+		if op.Type != skip {
+			b.WriteString(op.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", nil},
+		{"unchanged", "a day in Rome", "a day in Rome", []Op{{Equal, "a day in Rome"}}},
+		{"insert", "", "hello", []Op{{Insert, "hello"}}},
+		{"delete", "hello", "", []Op{{Delete, "hello"}}},
+		{"word replaced", "a day in Rome", "a day in Paris", []Op{
+			{Equal, "a day in "}, {Delete, "Rome"}, {Insert, "Paris"},
+		}},
+		{"word added in the middle", "a day in Rome", "a sunny day in Rome", []Op{
+			{Equal, "a "}, {Insert, "sunny "}, {Equal, "day in Rome"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordsRebuildsBothSides(t *testing.T) {
+	pairs := [][2]string{
+		{"the quick brown fox jumps over the lazy dog", "the slow brown cat jumps over a lazy dog today"},
+		{"one two three", "three two one"},
+		{"  leading and  double  spaces ", "leading and double spaces"},
+		{"line one\nline two\n", "line one\nline 2\nline three\n"},
+	}
+	for _, p := range pairs {
+		ops := Words(p[0], p[1])
+		if got := rebuild(ops, Insert); got != p[0] {
+			t.Errorf("old side of %q -> %q rebuilt as %q", p[0], p[1], got)
+		}
+		if got := rebuild(ops, Delete); got != p[1] {
+			t.Errorf("new side of %q -> %q rebuilt as %q", p[0], p[1], got)
+		}
+	}
+}
+
+func TestWordsFallsBackPastEditLimit(t *testing.T) {
+	// every word differs but the spaces between them match, which is more edits than allowed
+	a := strings.Repeat("x ", maxEdits) + "x"
+	b := strings.Repeat("y ", maxEdits) + "y"
+	ops := Words(a, b)
+	want := []Op{{Delete, a}, {Insert, b}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("got %d ops, want a single delete and insert", len(ops))
+	}
+}
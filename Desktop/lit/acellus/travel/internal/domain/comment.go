@@ -0,0 +1,31 @@
+/*
+this file validates comments left on entries
+*/
+
+package domain
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// the longest a comment can be, in characters
+const MaxCommentLength = 2000
+
+var (
+	ErrEmptyComment   = errors.New("comment cannot be empty")
+	ErrCommentTooLong = errors.New("comment is too long")
+)
+
+// NormalizeComment trims a comment body and checks its length
+func NormalizeComment(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", ErrEmptyComment
+	}
+	if utf8.RuneCountInString(body) > MaxCommentLength {
+		return "", ErrCommentTooLong
+	}
+	return body, nil
+}
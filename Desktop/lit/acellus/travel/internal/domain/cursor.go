@@ -0,0 +1,42 @@
+/*
+this file handles the cursors used to page through the feed
+a cursor points just past the last entry a page returned, by (created_at, id) so
+entries posted while someone is scrolling don't shift or repeat the pages after it
+*/
+
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of the last entry on a page
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Encode returns the cursor as an opaque url-safe string
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixMicro(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor reads a string made by Cursor.Encode
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var micros int64
+	var id int
+	if n, err := fmt.Sscanf(string(raw), "%d:%d", &micros, &id); err != nil || n != 2 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{CreatedAt: time.UnixMicro(micros).UTC(), ID: id}, nil
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{time.Date(2024, 7, 1, 12, 30, 15, 123456000, time.UTC), 42},
+		{time.Unix(0, 0).UTC(), 1},
+		{time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC), 0},
+	}
+	for _, want := range tests {
+		got, err := ParseCursor(want.Encode())
+		if err != nil {
+			t.Fatalf("ParseCursor(%v.Encode()): %v", want, err)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+			t.Errorf("round trip of %v gave %v", want, got)
+		}
+	}
+}
+
+func TestCursorDropsSubMicroseconds(t *testing.T) {
+	// postgres keeps microseconds, so the cursor doesn't need more
+	c := Cursor{time.Date(2024, 7, 1, 0, 0, 0, 1500, time.UTC), 7}
+	got, err := ParseCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CreatedAt.Nanosecond() != 1000 {
+		t.Errorf("got %d ns, want 1000", got.CreatedAt.Nanosecond())
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, s := range []string{"", "not base64!", enc("123"), enc("abc:1"), enc("1:x"), enc(":")} {
+		if _, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
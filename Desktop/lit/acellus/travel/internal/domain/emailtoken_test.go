@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckEmailToken(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	token := SignEmailToken(secret, PurposeVerifyEmail, 42, "Someone@Example.com", now.Add(VerifyEmailTTL))
+
+	// swap the user ID while keeping the signature
+	_, rest, _ := strings.Cut(token, ".")
+	otherUser := "43." + rest
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		purpose string
+		token   string
+		email   string
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", secret, PurposeVerifyEmail, token, "Someone@Example.com", now, false},
+		{"email case ignored", secret, PurposeVerifyEmail, token, "someone@example.com", now, false},
+		{"last second", secret, PurposeVerifyEmail, token, "someone@example.com", now.Add(VerifyEmailTTL), false},
+		{"expired", secret, PurposeVerifyEmail, token, "someone@example.com", now.Add(VerifyEmailTTL + time.Second), true},
+		{"email changed", secret, PurposeVerifyEmail, token, "other@example.com", now, true},
+		{"other purpose", secret, "reset-password", token, "someone@example.com", now, true},
+		{"other secret", []byte("another secret"), PurposeVerifyEmail, token, "someone@example.com", now, true},
+		{"user swapped", secret, PurposeVerifyEmail, otherUser, "someone@example.com", now, true},
+		{"truncated", secret, PurposeVerifyEmail, token[:len(token)-2], "someone@example.com", now, true},
+		{"bad signature encoding", secret, PurposeVerifyEmail, token + "!", "someone@example.com", now, true},
+		{"too few parts", secret, PurposeVerifyEmail, "42.123", "someone@example.com", now, true},
+		{"empty", secret, PurposeVerifyEmail, "", "someone@example.com", now, true},
+	}
+	for _, tt := range tests {
+		err := CheckEmailToken(tt.secret, tt.purpose, tt.token, tt.email, tt.now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckEmailToken = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && err != ErrInvalidToken {
+			t.Errorf("%s: got %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func TestEmailTokenUser(t *testing.T) {
+	token := SignEmailToken([]byte("s"), PurposeVerifyEmail, 42, "a@example.com", time.Now())
+	if id, err := EmailTokenUser(token); err != nil || id != 42 {
+		t.Errorf("EmailTokenUser = %d, %v, want 42", id, err)
+	}
+	for _, bad := range []string{"", "42", "x.1.sig", "1.2.3.4"} {
+		if _, err := EmailTokenUser(bad); err != ErrInvalidToken {
+			t.Errorf("EmailTokenUser(%q) = %v, want ErrInvalidToken", bad, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+this file stores passwords as PHC strings, $<id>$<params>$<salt>$<hash>, so the stored value says
+which algorithm and settings made it
+new passwords use DefaultHasher (argon2id), older hashes keep working with the hasher registered for
+their id and get replaced with a new one the next time the user logs in
+bcrypt's $2a$ / $2b$ hashes already look like this so they're read as they are
+*/
+
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher is one password hashing algorithm
+type Hasher interface {
+	// Hash returns the PHC string for password
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the PHC string encoded
+	Verify(password, encoded string) (bool, error)
+	// Outdated reports whether encoded was made by another algorithm or with different settings than this hasher uses now
+	Outdated(encoded string) bool
+}
+
+// ErrUnknownHash means a stored hash has an id no hasher is registered for
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+var hashers = map[string]Hasher{}
+
+// RegisterHasher makes h the hasher for PHC strings with any of ids
+func RegisterHasher(h Hasher, ids ...string) {
+	for _, id := range ids {
+		hashers[id] = h
+	}
+}
+
+// DefaultHasher hashes new passwords
+var DefaultHasher Hasher = Argon2id{Memory: 19 * 1024, Time: 2, Threads: 1}
+
+// LegacyBcrypt is what passwords were hashed with before argon2id, accounts keep
+// these hashes until their next login
+var LegacyBcrypt Hasher = Bcrypt{Cost: 14}
+
+func init() {
+	RegisterHasher(DefaultHasher, "argon2id")
+	RegisterHasher(LegacyBcrypt, "2a", "2b", "2y")
+}
+
+// hashID returns the id of a PHC string, "argon2id" for "$argon2id$v=19$..."
+func hashID(encoded string) string {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// HashPassword hashes password with DefaultHasher
+func HashPassword(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+// CheckPasswordHash reports whether password matches hash, made by any registered hasher
+func CheckPasswordHash(password, hash string) bool {
+	h, ok := hashers[hashID(hash)]
+	if !ok {
+		return false
+	}
+	match, err := h.Verify(password, hash)
+	return err == nil && match
+}
+
+// NeedsRehash reports whether hash should be replaced by HashPassword, because it was made
+// with another algorithm than DefaultHasher or with older settings
+func NeedsRehash(hash string) bool {
+	return DefaultHasher.Outdated(hash)
+}
+
+// DummyHash hashes a random password with whichever of hashers takes longest to check. Checking
+// a password against it when an email has no account takes as long as the slowest real check
+func DummyHash(hashers ...Hasher) (string, error) {
+	var slowest string
+	var longest time.Duration
+	for _, h := range hashers {
+		hash, err := h.Hash(rand.Text())
+		if err != nil {
+			return "", err
+		}
+		start := time.Now()
+		h.Verify(rand.Text(), hash)
+		if took := time.Since(start); slowest == "" || took > longest {
+			slowest, longest = hash, took
+		}
+	}
+	if slowest == "" {
+		return "", errors.New("no hashers given")
+	}
+	return slowest, nil
+}
+
+// Argon2id hashes with argon2id (RFC 9106), Memory is in KiB
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+func (a Argon2id) Outdated(encoded string) bool {
+	params, _, key, err := parseArgon2id(encoded)
+	return err != nil || params != a || len(key) != argon2KeyLen
+}
+
+// parseArgon2id splits "$argon2id$v=19$m=...,t=...,p=...$salt$key"
+func parseArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 || params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 hash")
+	}
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes with bcrypt, only the first 72 bytes of a password count
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(bytes), err
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap settings so the tests run quickly
+var (
+	testArgon2 = Argon2id{Memory: 64, Time: 1, Threads: 1}
+	testBcrypt = Bcrypt{Cost: bcrypt.MinCost}
+)
+
+func TestHashersRoundTrip(t *testing.T) {
+	for name, h := range map[string]Hasher{"argon2id": testArgon2, "bcrypt": testBcrypt} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id := hashID(hash); id == "" {
+				t.Fatalf("%q is not a PHC string", hash)
+			}
+			if !CheckPasswordHash("correct horse", hash) {
+				t.Error("the right password didn't match")
+			}
+			if CheckPasswordHash("correct horse ", hash) {
+				t.Error("a wrong password matched")
+			}
+			if h.Outdated(hash) {
+				t.Error("a fresh hash is outdated for the hasher that made it")
+			}
+		})
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := testArgon2.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected PHC string %q", hash)
+	}
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testArgon2 || len(salt) != argon2SaltLen || len(key) != argon2KeyLen {
+		t.Errorf("parsed %+v with %d byte salt and %d byte key", params, len(salt), len(key))
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := DefaultHasher.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := testBcrypt.Hash("pw")
+	weaker, _ := testArgon2.Hash("pw")
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"current argon2id", current, false},
+		{"bcrypt", legacy, true},
+		{"argon2id with other settings", weaker, true},
+		{"garbage", "not a hash", true},
+	}
+	for _, tt := range tests {
+		if got := NeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPasswordHashMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$unknown$v=1$abc",
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+		"$2a$04$tooshort",
+	} {
+		if CheckPasswordHash("pw", hash) {
+			t.Errorf("CheckPasswordHash accepted %q", hash)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	hash, err := DummyHash(testArgon2, testBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := hashID(hash); id != "argon2id" && id != "2a" {
+		t.Errorf("dummy %q wasn't made by one of the hashers", hash)
+	}
+	if _, err := DummyHash(); err == nil {
+		t.Error("expected an error without hashers")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+this file decides how long a client has to wait before trying to log in again
+failed attempts are counted per account (the email that was tried) and per IP address
+the first few are free, after that the wait doubles with each failure and past a limit
+the account or address is locked for a while
+counts are forgotten once nothing has failed for a window
+*/
+
+package domain
+
+import "time"
+
+// LoginLimit is the backoff and lockout policy for one kind of key
+type LoginLimit struct {
+	Free      int           // failures allowed before any wait
+	Base      time.Duration // the first wait, doubled for each failure after that
+	Max       time.Duration // the longest backoff wait
+	LockAfter int           // failures that lock the key
+	LockFor   time.Duration // how long a lock lasts
+	Window    time.Duration // failures older than this are forgotten, longer than LockFor
+}
+
+var (
+	// someone guessing one account's password
+	AccountLoginLimit = LoginLimit{Free: 3, Base: time.Second, Max: 5 * time.Minute, LockAfter: 10, LockFor: 15 * time.Minute, Window: time.Hour}
+	// someone trying many accounts from one address, looser because addresses can be shared
+	IPLoginLimit = LoginLimit{Free: 10, Base: time.Second, Max: 5 * time.Minute, LockAfter: 50, LockFor: time.Hour, Window: 2 * time.Hour}
+)
+
+// Wait returns how long to wait before the next attempt, after failures failed attempts the last of which was at last
+func (l LoginLimit) Wait(failures int, last, now time.Time) time.Duration {
+	if failures <= l.Free || now.Sub(last) > l.Window {
+		return 0
+	}
+
+	d := l.LockFor
+	if failures < l.LockAfter {
+		d = l.Max
+		if n := failures - l.Free - 1; n < 30 && l.Base<<n < l.Max {
+			d = l.Base << n
+		}
+	}
+	if wait := last.Add(d).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Locked reports whether failures is enough to lock the key
+func (l LoginLimit) Locked(failures int) bool {
+	return failures >= l.LockAfter
+}
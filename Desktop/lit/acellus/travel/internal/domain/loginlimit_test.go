@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimitWait(t *testing.T) {
+	l := LoginLimit{Free: 3, Base: time.Second, Max: 10 * time.Second, LockAfter: 9, LockFor: time.Minute, Window: time.Hour}
+	last := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		since    time.Duration
+		want     time.Duration
+	}{
+		{"no failures", 0, 0, 0},
+		{"free failures", 3, 0, 0},
+		{"first backoff", 4, 0, time.Second},
+		{"doubles", 5, 0, 2 * time.Second},
+		{"doubles again", 6, 0, 4 * time.Second},
+		{"and again", 7, 0, 8 * time.Second},
+		{"capped at max", 8, 0, 10 * time.Second},
+		{"part of the wait has passed", 6, 3 * time.Second, time.Second},
+		{"wait is over", 6, 5 * time.Second, 0},
+		{"locked", 9, 0, time.Minute},
+		{"still locked", 20, 30 * time.Second, 30 * time.Second},
+		{"lock is over", 9, 2 * time.Minute, 0},
+		{"forgotten after the window", 20, 2 * time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := l.Wait(tt.failures, last, last.Add(tt.since)); got != tt.want {
+			t.Errorf("%s: Wait(%d) after %s = %s, want %s", tt.name, tt.failures, tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimitWaitLargeCounts(t *testing.T) {
+	// a count past the lock that's been reset by the window shouldn't overflow the backoff shift
+	l := LoginLimit{Free: 0, Base: time.Second, Max: time.Hour, LockAfter: 1000, LockFor: time.Hour, Window: 2 * time.Hour}
+	now := time.Now()
+	if got := l.Wait(999, now, now); got != time.Hour {
+		t.Errorf("Wait(999) = %s, want the max", got)
+	}
+}
+
+func TestLoginLimitLocked(t *testing.T) {
+	for _, l := range []LoginLimit{AccountLoginLimit, IPLoginLimit} {
+		if l.Locked(l.LockAfter - 1) {
+			t.Errorf("locked one failure early at %d", l.LockAfter-1)
+		}
+		if !l.Locked(l.LockAfter) || !l.Locked(l.LockAfter+1) {
+			t.Errorf("not locked at %d failures", l.LockAfter)
+		}
+		// the lock has to outlast the backoff and be remembered for as long as it lasts
+		if l.LockFor < l.Max || l.Window <= l.LockFor {
+			t.Errorf("%+v: LockFor must be at least Max and shorter than Window", l)
+		}
+	}
+}
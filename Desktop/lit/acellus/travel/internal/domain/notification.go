@@ -0,0 +1,40 @@
+/*
+this file lists the kinds of notification a user can get
+every kind is on by default, users can turn each one off in their preferences
+*/
+
+package domain
+
+import "errors"
+
+// Notification types
+const (
+	NotifyFollow     = "follow"      // someone followed you
+	NotifyComment    = "comment"     // someone commented on your entry
+	NotifyReply      = "reply"       // someone replied to your comment
+	NotifyReaction   = "reaction"    // someone reacted to your entry
+	NotifyTripInvite = "trip_invite" // someone invited you to their trip
+	NotifyTripJoined = "trip_joined" // someone accepted your trip invitation
+)
+
+// NotificationTypes is every type, in the order preferences are listed
+var NotificationTypes = []string{
+	NotifyFollow,
+	NotifyComment,
+	NotifyReply,
+	NotifyReaction,
+	NotifyTripInvite,
+	NotifyTripJoined,
+}
+
+var ErrUnknownNotificationType = errors.New("unknown notification type")
+
+// ValidateNotificationType checks t is one of NotificationTypes
+func ValidateNotificationType(t string) error {
+	for _, known := range NotificationTypes {
+		if t == known {
+			return nil
+		}
+	}
+	return ErrUnknownNotificationType
+}
@@ -0,0 +1,17 @@
+/*
+this file handles password reset tokens
+a reset token is emailed to the user and can be used once to set a new password
+like session tokens only a hash is stored, and a token stops working after an hour
+*/
+
+package domain
+
+import "time"
+
+// how long a password reset link works
+const PasswordResetTTL = time.Hour
+
+// NewResetToken returns a random url-safe reset token and the hash to store for it
+func NewResetToken() (token, hash string, err error) {
+	return NewSessionToken()
+}
@@ -0,0 +1,36 @@
+/*
+this file lists the reactions people can leave on an entry
+reactions are stored by name so the emoji used to draw them can change without touching the data
+*/
+
+package domain
+
+import "errors"
+
+// Reaction pairs the stored name of a reaction with the emoji it's shown as
+type Reaction struct {
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+// Reactions is the fixed set, in display order
+var Reactions = []Reaction{
+	{Name: "heart", Emoji: "❤️"},
+	{Name: "wow", Emoji: "😮"},
+	{Name: "laugh", Emoji: "😂"},
+	{Name: "jealous", Emoji: "🤩"},
+	{Name: "clap", Emoji: "👏"},
+	{Name: "globe", Emoji: "🌍"},
+}
+
+var ErrUnknownReaction = errors.New("unknown reaction")
+
+// ValidateReaction checks name is one of Reactions
+func ValidateReaction(name string) error {
+	for _, r := range Reactions {
+		if r.Name == name {
+			return nil
+		}
+	}
+	return ErrUnknownReaction
+}
@@ -0,0 +1,37 @@
+/*
+this file handles session tokens
+a session token is handed to the client at login and sent back in the Authorization header
+we only ever store a sha256 hash of the token so a leaked database can't be used to log in
+*/
+
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// how long a session stays valid after login
+const SessionTTL = 30 * 24 * time.Hour
+
+// how long a stream ticket can wait before opening /events, it ends up in a URL so it's short
+const StreamTicketTTL = time.Minute
+
+// NewSessionToken returns a random url-safe token and the hash to store for it
+func NewSessionToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex sha256 of a token, this is what goes in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
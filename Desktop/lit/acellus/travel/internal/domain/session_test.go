@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestNewSessionToken(t *testing.T) {
+	token, hash, err := NewSessionToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(token); err != nil || len(b) != 32 {
+		t.Errorf("token %q isn't 32 url-safe bytes", token)
+	}
+	if hash != HashToken(token) || len(hash) != 64 {
+		t.Errorf("hash %q isn't the hex sha256 of the token", hash)
+	}
+
+	other, _, _ := NewSessionToken()
+	if other == token {
+		t.Error("two tokens were the same")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// sha256 of the empty string and of "abc"
+	tests := map[string]string{
+		"":    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"abc": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+	for token, want := range tests {
+		if got := HashToken(token); got != want {
+			t.Errorf("HashToken(%q) = %s, want %s", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+/*
+this file handles share links
+a share link lets anyone holding its token read one entry, nothing else
+tokens are generated and hashed exactly like session tokens, only the hash is stored
+*/
+
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// Entry visibility levels
+const (
+	VisibilityPrivate = "private" // only the author
+	VisibilityLink    = "link"    // the author and anyone with a share link
+	VisibilityPublic  = "public"  // everyone, and listed on the author's profile
+)
+
+// the longest a share link can be set to last
+const MaxShareTTL = 365 * 24 * time.Hour
+
+var ErrInvalidVisibility = errors.New("visibility must be private, link or public")
+
+// ValidateVisibility checks v is one of the known visibility levels
+func ValidateVisibility(v string) error {
+	switch v {
+	case VisibilityPrivate, VisibilityLink, VisibilityPublic:
+		return nil
+	}
+	return ErrInvalidVisibility
+}
+
+// NewShareToken returns a random url-safe token for a share link and the hash to store for it
+func NewShareToken() (token, hash string, err error) {
+	return NewSessionToken()
+}
@@ -0,0 +1,48 @@
+/*
+this file normalizes the free-form tags people put on entries
+"#Beach", " beach " and "BEACH" should all end up as the same tag
+*/
+
+package domain
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxTagLength    = 50
+	MaxTagsPerEntry = 20
+)
+
+var ErrTooManyTags = errors.New("an entry can have at most 20 tags")
+
+// NormalizeTag lowercases a tag, drops a leading # and collapses inner whitespace
+// it returns "" for tags that are empty once cleaned up
+func NormalizeTag(tag string) string {
+	tag = strings.TrimLeft(strings.TrimSpace(tag), "#")
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		tag = string([]rune(tag)[:MaxTagLength])
+	}
+	return strings.TrimSpace(tag)
+}
+
+// NormalizeTags normalizes each tag, dropping blanks and duplicates but keeping the original order
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > MaxTagsPerEntry {
+		return nil, ErrTooManyTags
+	}
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"beach", "beach"},
+		{"#Beach", "beach"},
+		{" BEACH ", "beach"},
+		{"##road   trip", "road trip"},
+		{"Café", "café"},
+		{"#", ""},
+		{"   ", ""},
+		{strings.Repeat("é", MaxTagLength+5), strings.Repeat("é", MaxTagLength)},
+		// cut on a rune count, and a space left at the cut is trimmed
+		{strings.Repeat("a", MaxTagLength-1) + " b", strings.Repeat("a", MaxTagLength-1)},
+	}
+	for _, tt := range tests {
+		if got := NormalizeTag(tt.in); got != tt.want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := NormalizeTags([]string{"Beach", "#beach", "", "road trip", " ROAD  TRIP ", "food"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"beach", "road trip", "food"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeTags = %q, want %q", got, want)
+	}
+
+	if got, err := NormalizeTags(nil); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("NormalizeTags(nil) = %#v, %v, want an empty slice", got, err)
+	}
+
+	many := make([]string, MaxTagsPerEntry+1)
+	for i := range many {
+		many[i] = strings.Repeat("x", i+1)
+	}
+	if _, err := NormalizeTags(many); err != ErrTooManyTags {
+		t.Errorf("%d tags: err = %v, want ErrTooManyTags", len(many), err)
+	}
+	// duplicates don't count towards the limit
+	if _, err := NormalizeTags(append(many[:MaxTagsPerEntry], "#x")); err != nil {
+		t.Errorf("%d tags with a duplicate: %v", MaxTagsPerEntry+1, err)
+	}
+}
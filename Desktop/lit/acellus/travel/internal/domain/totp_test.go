@@ -0,0 +1,140 @@
+package domain
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the RFC 6238 appendix B secret, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the code an authenticator app shows for secret at t
+func codeAt(secret string, t time.Time) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+func TestTOTPRFC6238(t *testing.T) {
+	// the SHA1 vectors from RFC 6238 appendix B, cut to the last 6 of their 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := codeAt(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	if _, err := codeAt("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("expected an error for a malformed secret")
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	at := func(d int64) string {
+		code, _ := codeAt(rfcSecret, now.Add(time.Duration(d*totpPeriod)*time.Second))
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current code", rfcSecret, at(0), step, true},
+		{"previous period", rfcSecret, at(-1), step - 1, true},
+		{"next period", rfcSecret, at(1), step + 1, true},
+		{"two periods old", rfcSecret, at(-2), 0, false},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", at(0), step, true},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"too short", rfcSecret, at(0)[:5], 0, false},
+		{"malformed secret", "not base32!", at(0), 0, false},
+	}
+	for _, tt := range tests {
+		gotStep, ok := CheckTOTP(tt.secret, tt.code, now)
+		if ok != tt.wantOK || gotStep != tt.wantStep {
+			t.Errorf("%s: CheckTOTP = %d, %v, want %d, %v", tt.name, gotStep, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("Travel Journal", "traveler@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Travel Journal:traveler@example.com" {
+		t.Errorf("unexpected URI %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Travel Journal" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codeAt(secret, time.Now()); err != nil || len(secret) != 32 {
+		t.Errorf("secret %q isn't 20 bytes of base32: %v", secret, err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q isn't like k3mf-9xq2", code)
+		}
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash %d doesn't match its code", i)
+		}
+		if seen[code] {
+			t.Errorf("code %q handed out twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("k3mf-9xq2")
+	for _, typed := range []string{"K3MF-9XQ2", "k3mf9xq2", " k3mf 9xq2 ", "k3-mf-9x-q2"} {
+		if got := HashRecoveryCode(typed); got != want {
+			t.Errorf("HashRecoveryCode(%q) differs from the code as handed out", typed)
+		}
+	}
+	if HashRecoveryCode("k3mf-9xq3") == want {
+		t.Error("a different code hashed the same")
+	}
+}
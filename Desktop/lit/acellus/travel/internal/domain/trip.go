@@ -0,0 +1,40 @@
+/*
+this file defines the roles someone can have on a trip
+the owner created the trip, editors can add and edit its entries and tracks,
+viewers can only look, members other than the owner are invited and have to accept
+*/
+
+package domain
+
+import "errors"
+
+// Trip roles, in increasing order of access
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// Invitation states of a trip member
+const (
+	MemberPending  = "pending"
+	MemberAccepted = "accepted"
+	MemberDeclined = "declined"
+)
+
+var ErrInvalidRole = errors.New("role must be editor or viewer")
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// ValidateMemberRole checks r is a role that can be given to an invited member
+func ValidateMemberRole(r string) error {
+	if r != RoleEditor && r != RoleViewer {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// HasRole reports whether role grants at least the access of need, "" (not a member) grants nothing
+func HasRole(role, need string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[need]
+}
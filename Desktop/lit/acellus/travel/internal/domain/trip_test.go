@@ -0,0 +1,35 @@
+package domain
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, need string
+		want       bool
+	}{
+		{RoleOwner, RoleOwner, true},
+		{RoleOwner, RoleEditor, true},
+		{RoleOwner, RoleViewer, true},
+		{RoleEditor, RoleOwner, false},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleViewer, true},
+		{RoleViewer, RoleEditor, false},
+		{RoleViewer, RoleViewer, true},
+		{"", RoleViewer, false},
+		{"", "", false},
+		{"admin", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := HasRole(tt.role, tt.need); got != tt.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", tt.role, tt.need, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMemberRole(t *testing.T) {
+	for role, ok := range map[string]bool{RoleEditor: true, RoleViewer: true, RoleOwner: false, "": false, "Editor": false} {
+		if err := ValidateMemberRole(role); (err == nil) != ok {
+			t.Errorf("ValidateMemberRole(%q) = %v, want ok %v", role, err, ok)
+		}
+	}
+}
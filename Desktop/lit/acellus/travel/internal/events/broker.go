@@ -0,0 +1,90 @@
+/*
+this file is the in-process side of live updates
+every open /events stream subscribes for its user, and an event is handed to
+the streams of each user it's addressed to
+slow streams are skipped rather than allowed to hold up everyone else,
+the client just refetches when it reconnects
+*/
+
+package events
+
+import "sync"
+
+// Event types
+const (
+	EntryCreated   = "entry.created"
+	EntryUpdated   = "entry.updated"
+	EntryDeleted   = "entry.deleted"
+	CommentCreated = "comment.created"
+	CommentUpdated = "comment.updated"
+	CommentDeleted = "comment.deleted"
+	Notification   = "notification"
+)
+
+// how many events a stream can fall behind before new ones are dropped for it
+const bufferSize = 32
+
+// Event says something changed, it carries IDs rather than data so clients fetch
+// what they need with their own permissions
+type Event struct {
+	Type           string `json:"type"`
+	EntryID        int    `json:"entry_id,omitempty"`
+	TripID         int    `json:"trip_id,omitempty"`
+	CommentID      int    `json:"comment_id,omitempty"`
+	NotificationID int    `json:"notification_id,omitempty"`
+	// Recipients are the users the event is for, it isn't sent to clients
+	Recipients []int `json:"recipients,omitempty"`
+}
+
+// Broker fans events out to the subscribers in this process
+type Broker struct {
+	mu   sync.Mutex
+	subs map[int]map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[int]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel of the user's events and a function to call when done with it
+func (b *Broker) Subscribe(userID int) (<-chan Event, func()) {
+	ch := make(chan Event, bufferSize)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan Event]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[userID], ch)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Deliver hands e to every local subscriber among its recipients without blocking
+func (b *Broker) Deliver(e Event) {
+	out := e
+	out.Recipients = nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[int]bool, len(e.Recipients))
+	for _, userID := range e.Recipients {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		for ch := range b.subs[userID] {
+			select {
+			case ch <- out:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+this file keeps live updates in sync across replicas
+events are published with pg_notify, and every replica LISTENs on the same channel
+and delivers what it hears to its own subscribers, including the replica that published it
+if the database can't be reached the event is still delivered locally
+*/
+
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the Postgres channel events travel on
+const channel = "travel_events"
+
+// Postgres refuses NOTIFY payloads of 8000 bytes or more
+const maxPayload = 7900
+
+// Hub publishes events through Postgres and delivers them to this process's Broker
+type Hub struct {
+	*Broker
+	db *sql.DB
+}
+
+// NewHub starts listening on dbURL and returns a Hub publishing through db
+func NewHub(db *sql.DB, dbURL string) *Hub {
+	h := &Hub{Broker: NewBroker(), db: db}
+
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener: %v", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		log.Printf("Event listener failed to start, live updates will only reach this replica: %v", err)
+		listener.Close()
+		h.db = nil
+		return h
+	}
+
+	go h.listen(listener)
+	return h
+}
+
+func (h *Hub) listen(l *pq.Listener) {
+	for {
+		select {
+		case n := <-l.Notify:
+			// nil after a reconnect, anything sent while disconnected is gone
+			if n == nil {
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("Ignoring malformed event: %v", err)
+				continue
+			}
+			h.Deliver(e)
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
+
+// Publish sends e to its recipients on every replica
+func (h *Hub) Publish(e Event) {
+	if len(e.Recipients) == 0 {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err == nil && h.db != nil && len(payload) < maxPayload {
+		if _, err = h.db.Exec(`SELECT pg_notify($1, $2)`, channel, string(payload)); err == nil {
+			return
+		}
+		log.Printf("Failed to publish %s event: %v", e.Type, err)
+	}
+	h.Deliver(e)
+}
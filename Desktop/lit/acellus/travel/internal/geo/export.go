@@ -0,0 +1,108 @@
+/*
+this file writes entries out as GeoJSON and KML
+so people can open their travels in tools like QGIS or Google Earth
+*/
+
+package geo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"time"
+)
+
+// Feature is one located entry to export
+type Feature struct {
+	ID       int
+	Title    string
+	Date     time.Time
+	Snippet  string
+	PhotoURL string // thumbnail, empty when the entry has no photos
+	Point
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	ID         int            `json:"id"`
+	Geometry   geoJSONPoint   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// WriteGeoJSON writes features as a GeoJSON FeatureCollection
+func WriteGeoJSON(w io.Writer, features []Feature) error {
+	out := geoJSONCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(features))}
+	for _, f := range features {
+		props := map[string]any{
+			"title":   f.Title,
+			"date":    f.Date.Format(time.RFC3339),
+			"snippet": f.Snippet,
+		}
+		if f.PhotoURL != "" {
+			props["photo_url"] = f.PhotoURL
+		}
+		out.Features = append(out.Features, geoJSONFeature{
+			Type: "Feature",
+			ID:   f.ID,
+			// GeoJSON positions are longitude first
+			Geometry:   geoJSONPoint{Type: "Point", Coordinates: [2]float64{f.Lng, f.Lat}},
+			Properties: props,
+		})
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+type kmlDocument struct {
+	XMLName  xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name     string         `xml:"Document>name"`
+	Features []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	ID          string `xml:"id,attr"`
+	Name        string `xml:"name"`
+	When        string `xml:"TimeStamp>when"`
+	Description string `xml:"description"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+// WriteKML writes features as a KML document called name
+func WriteKML(w io.Writer, name string, features []Feature) error {
+	doc := kmlDocument{Name: name, Features: make([]kmlPlacemark, 0, len(features))}
+	for _, f := range features {
+		// the description is shown as html in the placemark balloon
+		desc := "<p>" + html.EscapeString(f.Snippet) + "</p>"
+		if f.PhotoURL != "" {
+			desc += fmt.Sprintf(`<img src="%s" width="240"/>`, html.EscapeString(f.PhotoURL))
+		}
+		doc.Features = append(doc.Features, kmlPlacemark{
+			ID:          fmt.Sprintf("entry-%d", f.ID),
+			Name:        f.Title,
+			When:        f.Date.Format(time.RFC3339),
+			Description: desc,
+			Coordinates: fmt.Sprintf("%g,%g", f.Lng, f.Lat),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
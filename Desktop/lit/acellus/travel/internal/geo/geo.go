@@ -0,0 +1,98 @@
+/*
+this file has the basic coordinate math for entries
+it validates latitude/longitude pairs, measures great-circle distance
+and turns a point plus radius into a bounding box the database index can use
+*/
+
+package geo
+
+import (
+	"errors"
+	"math"
+)
+
+// mean radius of the earth in kilometers
+const EarthRadiusKM = 6371.0088
+
+// Point is a latitude/longitude pair in degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Box is a latitude/longitude rectangle, MinLng > MaxLng means it crosses the antimeridian
+type Box struct {
+	MinLat float64 `json:"min_lat"`
+	MinLng float64 `json:"min_lng"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLng float64 `json:"max_lng"`
+}
+
+var ErrInvalidCoordinates = errors.New("latitude must be between -90 and 90 and longitude between -180 and 180")
+
+// Validate checks that lat and lng are finite and within range
+func Validate(lat, lng float64) error {
+	if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return ErrInvalidCoordinates
+	}
+	return nil
+}
+
+// DistanceKM returns the haversine distance between two points
+func DistanceKM(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadiusKM * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBox returns a box that contains every point within radiusKM of center
+func BoundingBox(center Point, radiusKM float64) Box {
+	dLat := degrees(radiusKM / EarthRadiusKM)
+	box := Box{
+		MinLat: math.Max(-90, center.Lat-dLat),
+		MaxLat: math.Min(90, center.Lat+dLat),
+	}
+
+	// if the circle reaches a pole every longitude is inside it
+	if box.MinLat == -90 || box.MaxLat == 90 {
+		box.MinLng, box.MaxLng = -180, 180
+		return box
+	}
+
+	dLng := degrees(math.Asin(math.Min(1, math.Sin(radiusKM/EarthRadiusKM)/math.Cos(radians(center.Lat)))))
+	if dLng >= 180 {
+		box.MinLng, box.MaxLng = -180, 180
+		return box
+	}
+	box.MinLng = wrapLng(center.Lng - dLng)
+	box.MaxLng = wrapLng(center.Lng + dLng)
+	return box
+}
+
+// Contains reports whether p is inside the box
+func (b Box) Contains(p Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+}
+
+func wrapLng(lng float64) float64 {
+	if lng < -180 {
+		return lng + 360
+	}
+	if lng > 180 {
+		return lng - 360
+	}
+	return lng
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
@@ -0,0 +1,109 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		ok       bool
+	}{
+		{0, 0, true},
+		{90, 180, true},
+		{-90, -180, true},
+		{90.0001, 0, false},
+		{0, -180.0001, false},
+		{math.NaN(), 0, false},
+		{0, math.NaN(), false},
+		{math.Inf(1), 0, false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.lat, tt.lng); (err == nil) != tt.ok {
+			t.Errorf("Validate(%v, %v) = %v, want ok %v", tt.lat, tt.lng, err, tt.ok)
+		}
+	}
+}
+
+func TestDistanceKM(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{"same point", Point{48.8566, 2.3522}, Point{48.8566, 2.3522}, 0},
+		{"paris to london", Point{48.8566, 2.3522}, Point{51.5074, -0.1278}, 343.5},
+		{"quarter of the equator", Point{0, 0}, Point{0, 90}, EarthRadiusKM * math.Pi / 2},
+		{"across the antimeridian", Point{0, 179.5}, Point{0, -179.5}, EarthRadiusKM * math.Pi / 180},
+		{"pole to pole", Point{90, 0}, Point{-90, 0}, EarthRadiusKM * math.Pi},
+	}
+	for _, tt := range tests {
+		if got := DistanceKM(tt.a, tt.b); math.Abs(got-tt.want) > 0.5 {
+			t.Errorf("%s: DistanceKM = %.2f, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	tests := []struct {
+		name     string
+		center   Point
+		radiusKM float64
+		wrapped  bool
+		whole    bool
+	}{
+		{"mid latitude", Point{48.8566, 2.3522}, 50, false, false},
+		{"near the antimeridian", Point{-17.7, 179.9}, 100, true, false},
+		{"reaches the pole", Point{89.5, 10}, 100, false, true},
+		{"huge radius", Point{0, 0}, 15000, false, true},
+	}
+	for _, tt := range tests {
+		box := BoundingBox(tt.center, tt.radiusKM)
+		if got := box.MinLng > box.MaxLng; got != tt.wrapped {
+			t.Errorf("%s: %+v wrapped = %v, want %v", tt.name, box, got, tt.wrapped)
+		}
+		if got := box.MinLng == -180 && box.MaxLng == 180; got != tt.whole {
+			t.Errorf("%s: %+v spans every longitude = %v, want %v", tt.name, box, got, tt.whole)
+		}
+		if !box.Contains(tt.center) {
+			t.Errorf("%s: %+v doesn't contain its center", tt.name, box)
+		}
+
+		// points just inside the radius in each direction are in the box
+		for _, bearing := range []float64{0, 90, 180, 270} {
+			p := destination(tt.center, bearing, tt.radiusKM*0.999)
+			if !box.Contains(p) {
+				t.Errorf("%s: %+v doesn't contain %v at bearing %v", tt.name, box, p, bearing)
+			}
+		}
+	}
+}
+
+func TestBoxContains(t *testing.T) {
+	box := Box{MinLat: -20, MaxLat: -15, MinLng: 179, MaxLng: -179}
+	tests := []struct {
+		p    Point
+		want bool
+	}{
+		{Point{-17, 179.5}, true},
+		{Point{-17, -179.5}, true},
+		{Point{-17, 180}, true},
+		{Point{-17, 0}, false},
+		{Point{-10, 179.5}, false},
+	}
+	for _, tt := range tests {
+		if got := box.Contains(tt.p); got != tt.want {
+			t.Errorf("Contains(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+// the point distanceKM from p along bearing (degrees clockwise from north)
+func destination(p Point, bearing, distanceKM float64) Point {
+	d := distanceKM / EarthRadiusKM
+	lat1, lng1, b := radians(p.Lat), radians(p.Lng), radians(bearing)
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lng2 := lng1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return Point{degrees(lat2), wrapLng(degrees(lng2))}
+}
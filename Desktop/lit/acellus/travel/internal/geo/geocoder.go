@@ -0,0 +1,168 @@
+/*
+this file does offline reverse geocoding
+it loads a list of places into memory once at startup and answers
+"what is the closest city to this point" without calling any outside service
+places are bucketed into a one degree grid so a lookup only looks at nearby cells
+*/
+
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//go:embed data/cities.tsv
+var bundledCities []byte
+
+// Place is a populated place from the dataset
+type Place struct {
+	Name        string
+	Region      string
+	CountryCode string
+	TimeZone    string
+	Point
+}
+
+// Label is the human readable location text for a place
+func (p Place) Label() string {
+	parts := []string{p.Name}
+	if p.Region != "" && p.Region != p.Name {
+		parts = append(parts, p.Region)
+	}
+	parts = append(parts, p.CountryCode)
+	return strings.Join(parts, ", ")
+}
+
+type cell struct{ lat, lng int }
+
+// Geocoder finds the nearest known place to a point
+type Geocoder struct {
+	grid  map[cell][]Place
+	count int
+}
+
+// NewGeocoder indexes places for lookups
+func NewGeocoder(places []Place) *Geocoder {
+	g := &Geocoder{grid: make(map[cell][]Place), count: len(places)}
+	for _, p := range places {
+		c := cellFor(p.Point)
+		g.grid[c] = append(g.grid[c], p)
+	}
+	return g
+}
+
+// LoadGeocoder reads places from path, or from the bundled city list when path is empty
+func LoadGeocoder(path string) (*Geocoder, error) {
+	var r io.Reader = bytes.NewReader(bundledCities)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	places, err := ParsePlaces(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewGeocoder(places), nil
+}
+
+// Len is the number of places loaded
+func (g *Geocoder) Len() int { return g.count }
+
+// Nearest returns the closest place within maxKM of p
+func (g *Geocoder) Nearest(p Point, maxKM float64) (Place, bool) {
+	box := BoundingBox(p, maxKM)
+	minLat, maxLat := int(math.Floor(box.MinLat)), int(math.Floor(box.MaxLat))
+	minLng, maxLng := int(math.Floor(box.MinLng)), int(math.Floor(box.MaxLng))
+	if box.MinLng > box.MaxLng {
+		// box crosses the antimeridian, walk past 180 and wrap the cell index
+		maxLng += 360
+	}
+
+	var best Place
+	bestDist := math.Inf(1)
+	for lat := minLat; lat <= maxLat; lat++ {
+		for lng := minLng; lng <= maxLng; lng++ {
+			for _, candidate := range g.grid[cell{lat, wrapCell(lng)}] {
+				if d := DistanceKM(p, candidate.Point); d < bestDist {
+					best, bestDist = candidate, d
+				}
+			}
+		}
+	}
+	return best, bestDist <= maxKM
+}
+
+// ParsePlaces reads either the bundled format
+// (name, region, country code, latitude, longitude, timezone)
+// or a GeoNames cities file, where the region is the admin1 code
+func ParsePlaces(r io.Reader) ([]Place, error) {
+	var places []Place
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		var place Place
+		var latStr, lngStr string
+		switch {
+		case len(fields) >= 18:
+			// geonameid, name, asciiname, alternatenames, latitude, longitude,
+			// feature class, feature code, country code, cc2, admin1 code, ... timezone
+			place = Place{Name: fields[1], CountryCode: fields[8], Region: fields[10], TimeZone: fields[17]}
+			latStr, lngStr = fields[4], fields[5]
+		case len(fields) == 6:
+			place = Place{Name: fields[0], Region: fields[1], CountryCode: fields[2], TimeZone: fields[5]}
+			latStr, lngStr = fields[3], fields[4]
+		default:
+			return nil, fmt.Errorf("line %d: unexpected number of fields (%d)", line, len(fields))
+		}
+
+		var err error
+		if place.Lat, err = strconv.ParseFloat(latStr, 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad latitude: %w", line, err)
+		}
+		if place.Lng, err = strconv.ParseFloat(lngStr, 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad longitude: %w", line, err)
+		}
+		if err := Validate(place.Lat, place.Lng); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		place.CountryCode = strings.ToUpper(place.CountryCode)
+		places = append(places, place)
+	}
+	return places, scanner.Err()
+}
+
+func cellFor(p Point) cell {
+	return cell{int(math.Floor(p.Lat)), wrapCell(int(math.Floor(p.Lng)))}
+}
+
+// keep cell longitudes in [-180, 180)
+func wrapCell(lng int) int {
+	for lng >= 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
@@ -0,0 +1,90 @@
+package geo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeocoderNearest(t *testing.T) {
+	g := NewGeocoder([]Place{
+		{Name: "Paris", Region: "Ile-de-France", CountryCode: "FR", Point: Point{48.8534, 2.3488}},
+		{Name: "Versailles", Region: "Ile-de-France", CountryCode: "FR", Point: Point{48.8014, 2.1301}},
+		{Name: "Suva", Region: "Central", CountryCode: "FJ", Point: Point{-18.1416, 178.4415}},
+		{Name: "Taveuni", Region: "Northern", CountryCode: "FJ", Point: Point{-16.85, -179.97}},
+	})
+
+	tests := []struct {
+		name  string
+		p     Point
+		maxKM float64
+		want  string
+	}{
+		{"exact", Point{48.8534, 2.3488}, 25, "Paris"},
+		{"closer to versailles", Point{48.81, 2.15}, 25, "Versailles"},
+		{"across a cell edge", Point{48.99, 2.3488}, 25, "Paris"},
+		{"across the antimeridian", Point{-16.85, 179.99}, 25, "Taveuni"},
+		{"too far", Point{50, 2.3488}, 25, ""},
+		{"middle of the ocean", Point{0, -140}, 150, ""},
+	}
+	for _, tt := range tests {
+		place, ok := g.Nearest(tt.p, tt.maxKM)
+		if ok != (tt.want != "") || (ok && place.Name != tt.want) {
+			t.Errorf("%s: Nearest(%v) = %q, %v, want %q", tt.name, tt.p, place.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestPlaceLabel(t *testing.T) {
+	tests := []struct {
+		place Place
+		want  string
+	}{
+		{Place{Name: "Kyoto", Region: "Kyoto", CountryCode: "JP"}, "Kyoto, JP"},
+		{Place{Name: "Paris", Region: "Ile-de-France", CountryCode: "FR"}, "Paris, Ile-de-France, FR"},
+		{Place{Name: "Monaco", CountryCode: "MC"}, "Monaco, MC"},
+	}
+	for _, tt := range tests {
+		if got := tt.place.Label(); got != tt.want {
+			t.Errorf("Label() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParsePlaces(t *testing.T) {
+	bundled := "# comment\nKyoto\tKyoto\tjp\t35.0211\t135.7538\tAsia/Tokyo\n\n"
+	geonames := strings.Join([]string{"1857910", "Kyoto", "Kyoto", "", "35.02107", "135.75385", "P", "PPLA", "JP", "", "22",
+		"", "", "", "1459640", "", "50", "Asia/Tokyo", "2024-01-01"}, "\t")
+
+	for name, input := range map[string]string{"bundled": bundled, "geonames": geonames} {
+		places, err := ParsePlaces(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(places) != 1 || places[0].Name != "Kyoto" || places[0].CountryCode != "JP" || places[0].TimeZone != "Asia/Tokyo" {
+			t.Errorf("%s: got %+v", name, places)
+		}
+	}
+
+	for _, bad := range []string{
+		"Kyoto\tKyoto\tJP\t35.0211\n",
+		"Kyoto\tKyoto\tJP\tnorth\t135.7538\tAsia/Tokyo\n",
+		"Kyoto\tKyoto\tJP\t35.0211\t235.7538\tAsia/Tokyo\n",
+	} {
+		if _, err := ParsePlaces(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestBundledCities(t *testing.T) {
+	g, err := LoadGeocoder("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() == 0 {
+		t.Fatal("no bundled places")
+	}
+	if place, ok := g.Nearest(Point{35.0116, 135.7681}, 25); !ok || place.Name != "Kyoto" {
+		t.Errorf("central Kyoto resolved to %q, %v", place.Name, ok)
+	}
+}
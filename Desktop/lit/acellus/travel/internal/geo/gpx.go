@@ -0,0 +1,266 @@
+/*
+this file reads GPX files recorded by GPS watches and phones
+it pulls out the track points and waypoints, works out distance, elevation gain and duration
+and simplifies the route into an encoded polyline small enough to send to the trip page
+*/
+
+package geo
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// TrackPoint is one recorded GPS fix
+type TrackPoint struct {
+	Point
+	Elevation *float64
+	Time      *time.Time
+}
+
+// Waypoint is a named point of interest saved in the GPX file
+type Waypoint struct {
+	Name      string     `json:"name"`
+	Lat       float64    `json:"lat"`
+	Lng       float64    `json:"lng"`
+	Elevation *float64   `json:"elevation_m,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// Track is everything we keep from an imported GPX file
+type Track struct {
+	Name            string
+	Segments        [][]TrackPoint
+	Waypoints       []Waypoint
+	DistanceKM      float64
+	ElevationGainM  float64
+	StartedAt       *time.Time
+	EndedAt         *time.Time
+	DurationSeconds int64
+}
+
+// the gpx schema, only the parts we use
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Tracks    []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele"`
+	Time string   `xml:"time"`
+	Name string   `xml:"name"`
+}
+
+var ErrEmptyGPX = errors.New("gpx file has no track or route points")
+
+// elevation changes smaller than this are treated as gps noise
+const elevationNoiseM = 3.0
+
+// ParseGPX reads a GPX document and computes its statistics
+func ParseGPX(r io.Reader) (*Track, error) {
+	var doc gpxFile
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	track := &Track{Name: doc.Metadata.Name}
+	for _, trk := range doc.Tracks {
+		if track.Name == "" {
+			track.Name = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			points, err := convertPoints(seg.Points)
+			if err != nil {
+				return nil, err
+			}
+			if len(points) > 0 {
+				track.Segments = append(track.Segments, points)
+			}
+		}
+	}
+	// planned routes are used when the file has no recorded track
+	if len(track.Segments) == 0 {
+		for _, rte := range doc.Routes {
+			if track.Name == "" {
+				track.Name = rte.Name
+			}
+			points, err := convertPoints(rte.Points)
+			if err != nil {
+				return nil, err
+			}
+			if len(points) > 0 {
+				track.Segments = append(track.Segments, points)
+			}
+		}
+	}
+
+	for _, w := range doc.Waypoints {
+		if err := Validate(w.Lat, w.Lon); err != nil {
+			return nil, err
+		}
+		track.Waypoints = append(track.Waypoints, Waypoint{
+			Name:      strings.TrimSpace(w.Name),
+			Lat:       w.Lat,
+			Lng:       w.Lon,
+			Elevation: w.Ele,
+			Time:      parseGPXTime(w.Time),
+		})
+	}
+
+	if len(track.Segments) == 0 {
+		return nil, ErrEmptyGPX
+	}
+	track.computeStats()
+	return track, nil
+}
+
+func convertPoints(in []gpxPoint) ([]TrackPoint, error) {
+	out := make([]TrackPoint, 0, len(in))
+	for _, p := range in {
+		if err := Validate(p.Lat, p.Lon); err != nil {
+			return nil, err
+		}
+		out = append(out, TrackPoint{Point: Point{Lat: p.Lat, Lng: p.Lon}, Elevation: p.Ele, Time: parseGPXTime(p.Time)})
+	}
+	return out, nil
+}
+
+func parseGPXTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func (t *Track) computeStats() {
+	for _, seg := range t.Segments {
+		var ref *float64
+		for i, p := range seg {
+			if i > 0 {
+				t.DistanceKM += DistanceKM(seg[i-1].Point, p.Point)
+			}
+
+			// only count a climb once it rises clearly above the last low point
+			if p.Elevation != nil {
+				switch {
+				case ref == nil || *p.Elevation < *ref:
+					ref = p.Elevation
+				case *p.Elevation-*ref >= elevationNoiseM:
+					t.ElevationGainM += *p.Elevation - *ref
+					ref = p.Elevation
+				}
+			}
+
+			if p.Time != nil {
+				if t.StartedAt == nil || p.Time.Before(*t.StartedAt) {
+					t.StartedAt = p.Time
+				}
+				if t.EndedAt == nil || p.Time.After(*t.EndedAt) {
+					t.EndedAt = p.Time
+				}
+			}
+		}
+	}
+	if t.StartedAt != nil {
+		t.DurationSeconds = int64(t.EndedAt.Sub(*t.StartedAt).Seconds())
+	}
+}
+
+// Simplify drops points that stay within toleranceM of the line between their neighbours (Douglas-Peucker)
+func Simplify(points []Point, toleranceM float64) []Point {
+	if len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// iterative so very long tracks can't blow the stack
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		maxDist, index := 0.0, -1
+		for i := s.first + 1; i < s.last; i++ {
+			if d := crossTrackM(points[i], points[s.first], points[s.last]); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > toleranceM {
+			keep[index] = true
+			stack = append(stack, span{s.first, index}, span{index, s.last})
+		}
+	}
+
+	out := make([]Point, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// distance in meters from p to the segment a-b, using a local flat projection
+// which is plenty accurate at the scale of neighbouring gps points
+func crossTrackM(p, a, b Point) float64 {
+	metersPerDegLat := EarthRadiusKM * 1000 * math.Pi / 180
+	metersPerDegLng := metersPerDegLat * math.Cos(radians(a.Lat))
+
+	px, py := (p.Lng-a.Lng)*metersPerDegLng, (p.Lat-a.Lat)*metersPerDegLat
+	bx, by := (b.Lng-a.Lng)*metersPerDegLng, (b.Lat-a.Lat)*metersPerDegLat
+
+	lenSq := bx*bx + by*by
+	if lenSq == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lenSq))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// EncodePolyline encodes points with Google's polyline algorithm (5 decimal places)
+func EncodePolyline(points []Point) string {
+	var sb strings.Builder
+	var prevLat, prevLng int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lng := int64(math.Round(p.Lng * 1e5))
+		encodePolylineValue(&sb, lat-prevLat)
+		encodePolylineValue(&sb, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return sb.String()
+}
+
+func encodePolylineValue(sb *strings.Builder, v int64) {
+	u := uint64(v << 1)
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		sb.WriteByte(byte((0x20 | (u & 0x1f)) + 63))
+		u >>= 5
+	}
+	sb.WriteByte(byte(u + 63))
+}
@@ -0,0 +1,131 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+const sampleGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test">
+  <metadata><name>Morning hike</name></metadata>
+  <wpt lat="46.5" lon="7.5"><name> Summit </name><ele>2100</ele></wpt>
+  <trk>
+    <name>Track name</name>
+    <trkseg>
+      <trkpt lat="46.50" lon="7.50"><ele>1000</ele><time>2024-07-01T08:00:00Z</time></trkpt>
+      <trkpt lat="46.51" lon="7.50"><ele>1002</ele><time>2024-07-01T08:10:00Z</time></trkpt>
+      <trkpt lat="46.52" lon="7.50"><ele>1050</ele><time>2024-07-01T08:20:00Z</time></trkpt>
+      <trkpt lat="46.53" lon="7.50"><ele>1040</ele><time>2024-07-01T08:30:00Z</time></trkpt>
+      <trkpt lat="46.54" lon="7.50"><ele>1060</ele><time>2024-07-01T09:00:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestParseGPX(t *testing.T) {
+	track, err := ParseGPX(strings.NewReader(sampleGPX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Morning hike" {
+		t.Errorf("Name = %q, want the metadata name", track.Name)
+	}
+	if len(track.Segments) != 1 || len(track.Segments[0]) != 5 {
+		t.Fatalf("got segments %v, want one of 5 points", track.Segments)
+	}
+	if len(track.Waypoints) != 1 || track.Waypoints[0].Name != "Summit" {
+		t.Errorf("waypoints = %+v, want a trimmed Summit", track.Waypoints)
+	}
+
+	wantKM := DistanceKM(Point{46.50, 7.50}, Point{46.54, 7.50})
+	if math.Abs(track.DistanceKM-wantKM) > 1e-6 {
+		t.Errorf("DistanceKM = %v, want %v", track.DistanceKM, wantKM)
+	}
+	// the 2 m step alone is noise and counts once the climb reaches 1050, then +20 from the 1040 dip
+	if track.ElevationGainM != 50+20 {
+		t.Errorf("ElevationGainM = %v, want 70", track.ElevationGainM)
+	}
+	if track.DurationSeconds != 3600 {
+		t.Errorf("DurationSeconds = %d, want 3600", track.DurationSeconds)
+	}
+}
+
+func TestParseGPXRouteFallback(t *testing.T) {
+	doc := `<gpx><rte><name>Planned</name>
+		<rtept lat="1" lon="2"/><rtept lat="1.1" lon="2.1"/>
+	</rte></gpx>`
+	track, err := ParseGPX(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Planned" || len(track.Segments) != 1 || len(track.Segments[0]) != 2 {
+		t.Errorf("got %q with segments %v", track.Name, track.Segments)
+	}
+	if track.StartedAt != nil || track.DurationSeconds != 0 {
+		t.Error("a route without times shouldn't have a duration")
+	}
+}
+
+func TestParseGPXErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want error
+	}{
+		{"no points", `<gpx><wpt lat="1" lon="2"/></gpx>`, ErrEmptyGPX},
+		{"track point out of range", `<gpx><trk><trkseg><trkpt lat="91" lon="0"/></trkseg></trk></gpx>`, ErrInvalidCoordinates},
+		{"waypoint out of range", `<gpx><wpt lat="0" lon="181"/><trk><trkseg><trkpt lat="1" lon="1"/></trkseg></trk></gpx>`, ErrInvalidCoordinates},
+	}
+	for _, tt := range tests {
+		if _, err := ParseGPX(strings.NewReader(tt.doc)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := ParseGPX(strings.NewReader("not xml")); err == nil {
+		t.Error("expected an error for a document that isn't XML")
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	// about 1.1 km between points along the equator, the middle one is 11 m off the line
+	line := []Point{{0, 0}, {0.0001, 0.01}, {0, 0.02}}
+	tests := []struct {
+		name       string
+		points     []Point
+		toleranceM float64
+		want       int
+	}{
+		{"too short to simplify", line[:2], 100, 2},
+		{"within tolerance", line, 20, 2},
+		{"outside tolerance", line, 5, 3},
+		{"collinear", []Point{{0, 0}, {0, 0.01}, {0, 0.02}, {0, 0.03}}, 1, 2},
+	}
+	for _, tt := range tests {
+		got := Simplify(tt.points, tt.toleranceM)
+		if len(got) != tt.want {
+			t.Errorf("%s: kept %d points, want %d", tt.name, len(got), tt.want)
+			continue
+		}
+		if got[0] != tt.points[0] || got[len(got)-1] != tt.points[len(tt.points)-1] {
+			t.Errorf("%s: the end points weren't kept", tt.name)
+		}
+	}
+}
+
+func TestEncodePolyline(t *testing.T) {
+	tests := []struct {
+		points []Point
+		want   string
+	}{
+		{nil, ""},
+		// the example from Google's polyline algorithm documentation
+		{[]Point{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{[]Point{{0, 0}}, "??"},
+	}
+	for _, tt := range tests {
+		if got := EncodePolyline(tt.points); got != tt.want {
+			t.Errorf("EncodePolyline(%v) = %q, want %q", tt.points, got, tt.want)
+		}
+	}
+}
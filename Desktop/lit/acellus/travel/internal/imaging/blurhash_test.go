@@ -0,0 +1,78 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solid(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// gradient runs red along x and green along y with blue fixed
+func gradient(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / (w - 1)), uint8(y * 255 / (h - 1)), 128, 255})
+		}
+	}
+	return img
+}
+
+// expected hashes were computed with a straight port of the reference encoder
+// (https://github.com/woltapp/blurhash), the images are smaller than maxSamples
+// so every pixel is used just as the reference does
+func TestBlurHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    image.Image
+		cx, cy int
+		want   string
+	}{
+		{"gradient", gradient(8, 6), 4, 3, "LyI5er3AfQxtz4NKfQnSeXf7fQf7"},
+		{"white", solid(4, 4, color.White), 4, 3, "L~TSUA~qfQ~q~q%MfQ%MfQfQfQfQ"},
+		{"red dc only", solid(4, 4, color.RGBA{255, 0, 0, 255}), 1, 1, "00TI:j"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BlurHash(tt.img, tt.cx, tt.cy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("BlurHash = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlurHashLength(t *testing.T) {
+	// 1 size char, 1 max char, 4 for the DC and 2 per AC component
+	got, err := BlurHash(gradient(200, 120), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 6 + 2*(4*3-1); len(got) != want {
+		t.Errorf("len(%q) = %d, want %d", got, len(got), want)
+	}
+}
+
+func TestBlurHashErrors(t *testing.T) {
+	if _, err := BlurHash(gradient(8, 6), 0, 3); err == nil {
+		t.Error("expected an error for 0 x components")
+	}
+	if _, err := BlurHash(gradient(8, 6), 4, 10); err == nil {
+		t.Error("expected an error for 10 y components")
+	}
+	if _, err := BlurHash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3); err == nil {
+		t.Error("expected an error for an empty image")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+this file sends email
+handlers only see the Mailer interface, SMTPMailer delivers through any SMTP server
+(a local sink such as MailHog or smtp4dev works for development) and LogMailer
+just writes messages to the log when no server is configured
+*/
+
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTPMailer sends through an SMTP server, Username and Password are optional
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers m, giving up when ctx is done
+func (s SMTPMailer) Send(ctx context.Context, m Message) error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("mail: header contains a line break")
+	}
+
+	// From may carry a display name for the header, the envelope only takes the bare address
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("mail: sender %q: %w", s.From, err)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + m.To,
+		"Subject: " + m.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.ReplaceAll(m.Body, "\n", "\r\n"),
+	}, "\r\n")
+
+	// net/smtp has no context support, run it aside so a hung server can't hold the caller
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, from.Address, []string{m.To}, []byte(msg))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes messages to the log instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, m Message) error {
+	log.Printf("Mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
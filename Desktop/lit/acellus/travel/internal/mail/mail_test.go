@@ -0,0 +1,112 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sink is an SMTP server that accepts one message and records what it was told
+type sink struct {
+	addr     string
+	mailFrom chan string
+	rcptTo   chan string
+	data     chan string
+}
+
+func newSink(t *testing.T) *sink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &sink{addr: ln.Addr().String(), mailFrom: make(chan string, 1), rcptTo: make(chan string, 1), data: make(chan string, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 sink ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 sink")
+			case "MAIL":
+				s.mailFrom <- arg
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				s.rcptTo <- arg
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				body, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data <- string(body)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return s
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	s := newSink(t)
+	m := SMTPMailer{Addr: s.addr, From: "Travel Journal <no-reply@localhost>"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, Message{To: "traveler@example.com", Subject: "Hello", Body: "line one\nline two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-s.mailFrom; got != "FROM:<no-reply@localhost>" {
+		t.Errorf("envelope sender %q, want the bare address", got)
+	}
+	if got := <-s.rcptTo; got != "TO:<traveler@example.com>" {
+		t.Errorf("envelope recipient %q", got)
+	}
+	data := <-s.data
+	for _, want := range []string{"From: Travel Journal <no-reply@localhost>\n", "To: traveler@example.com\n", "Subject: Hello\n", "\nline one\nline two"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		msg  Message
+	}{
+		{"line break in recipient", "no-reply@localhost", Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "Hi"}},
+		{"line break in subject", "no-reply@localhost", Message{To: "a@example.com", Subject: "Hi\nBcc: b@example.com"}},
+		{"malformed sender", "Travel Journal", Message{To: "a@example.com", Subject: "Hi"}},
+	}
+	for _, tt := range tests {
+		// nothing should be dialed, the address is never listened on
+		m := SMTPMailer{Addr: "127.0.0.1:1", From: tt.from}
+		if err := m.Send(context.Background(), tt.msg); err == nil || !strings.HasPrefix(err.Error(), "mail: ") {
+			t.Errorf("%s: err = %v, want a mail error before dialing", tt.name, err)
+		}
+	}
+}
@@ -6,20 +6,258 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(255) UNIQUE NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA name
+    email_verified_at TIMESTAMPTZ, -- NULL while the account is pending verification
+    verification_sent_at TIMESTAMPTZ,
+    totp_secret VARCHAR(64), -- set by 2FA setup, only used once totp_enabled_at is set
+    totp_enabled_at TIMESTAMPTZ,
+    totp_last_step BIGINT, -- the last TOTP code accepted, so it can't be used twice
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sessions table (only a sha256 of the bearer token is stored)
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Trips table
+CREATE TABLE IF NOT EXISTS trips (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Entries table
 CREATE TABLE IF NOT EXISTS entries (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id),
+    trip_id INTEGER REFERENCES trips(id),
     title VARCHAR(255) NOT NULL,
     content TEXT NOT NULL,
     location VARCHAR(255),
+    country_code CHAR(2), -- ISO 3166 code resolved from latitude/longitude
+    place VARCHAR(255), -- nearby city resolved from latitude/longitude, counted in stats
+    geocoded_at TIMESTAMPTZ, -- when latitude/longitude were last resolved
+    latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90),
+    longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180),
     photos TEXT[], -- PostgreSQL array for photos
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), -- when the moment happened
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA zone the entry was written in
+    status VARCHAR(16) NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'published')),
+    visibility VARCHAR(16) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'link', 'public')),
+    version INTEGER NOT NULL DEFAULT 1, -- bumped on every edit, used for If-Match
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ, -- set while in the trash, purged 30 days later
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tracks table (simplified GPX routes attached to trips)
+CREATE TABLE IF NOT EXISTS tracks (
+    id SERIAL PRIMARY KEY,
+    trip_id INTEGER NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    distance_km DOUBLE PRECISION NOT NULL,
+    elevation_gain_m DOUBLE PRECISION NOT NULL,
+    duration_seconds BIGINT NOT NULL,
+    started_at TIMESTAMPTZ,
+    ended_at TIMESTAMPTZ,
+    polylines TEXT[] NOT NULL, -- Google encoded polyline per segment
+    waypoints JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tags (names stored normalized to lowercase) and the entries they are on
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS entry_tags (
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (entry_id, tag_id)
+);
+
+-- Entry revisions (append-only snapshot after every change)
+CREATE TABLE IF NOT EXISTS entry_revisions (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    rev INTEGER NOT NULL, -- entries.version at the time of the snapshot
+    author_id INTEGER REFERENCES users(id),
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    location VARCHAR(255),
+    country_code CHAR(2),
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    photos TEXT[],
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    trip_id INTEGER,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    time_zone VARCHAR(64) NOT NULL,
+    status VARCHAR(16), -- NULL for revisions recorded before the status was kept
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (entry_id, rev)
+);
+
+-- Share links (read-only access to one entry, only the sha256 of the token is stored)
+CREATE TABLE IF NOT EXISTS share_links (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ, -- NULL means until revoked
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Trip members (people invited to someone else's trip; the owner is trips.user_id)
+CREATE TABLE IF NOT EXISTS trip_members (
+    trip_id INTEGER NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('editor', 'viewer')),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    invited_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMPTZ,
+    PRIMARY KEY (trip_id, user_id)
+);
+
+-- Comments (threaded through parent_id; deleted comments keep their place with an empty body)
+CREATE TABLE IF NOT EXISTS comments (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    edited_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Reactions (one of a fixed set of names per user and entry, counted per entry)
+CREATE TABLE IF NOT EXISTS reactions (
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reaction VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (entry_id, user_id, reaction)
+);
+
+-- Favorites (entries a user has bookmarked)
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    entry_id INTEGER NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, entry_id)
+);
+
+-- Notifications (read_at is set once the user has seen it)
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(32) NOT NULL, -- follow, comment, reply, reaction, trip_invite, trip_joined
+    entry_id INTEGER REFERENCES entries(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    trip_id INTEGER REFERENCES trips(id) ON DELETE CASCADE,
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notification preferences (a row only for types the user has changed; the default is on)
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(32) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+
+-- Password reset tokens (single use, only the sha256 of the token is stored)
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+-- Two-factor recovery codes (each works once, only the sha256 is stored)
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- Logins that passed the password check and are waiting for the second factor
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Recent failed logins per account (email) and per client address, for backoff and lockout
+CREATE TABLE IF NOT EXISTS login_failures (
+    scope VARCHAR(16) NOT NULL, -- account or ip
+    key VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+-- Single use tickets that open /events for a session, EventSource can't send the Authorization header
+CREATE TABLE IF NOT EXISTS stream_tickets (
+    token_hash CHAR(64) PRIMARY KEY,
+    session_hash CHAR(64) NOT NULL REFERENCES sessions(token_hash) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Follows (the feed is built from this at read time)
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+-- Photos table (metadata computed at upload time, keyed by URL)
+CREATE TABLE IF NOT EXISTS photos (
+    url VARCHAR(512) PRIMARY KEY,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blurhash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_entries_user_id ON entries(user_id);
-CREATE INDEX IF NOT EXISTS idx_entries_created_at ON entries(created_at DESC);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_entries_created_at ON entries(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_entries_trip_id ON entries(trip_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_entries_user_coords ON entries(user_id, latitude, longitude) WHERE latitude IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_entries_user_country ON entries(user_id, country_code);
+CREATE INDEX IF NOT EXISTS idx_tracks_trip_id ON tracks(trip_id);
+CREATE INDEX IF NOT EXISTS idx_entries_user_occurred ON entries(user_id, occurred_at DESC);
+CREATE INDEX IF NOT EXISTS idx_entry_tags_tag_id ON entry_tags(tag_id);
+CREATE INDEX IF NOT EXISTS idx_entries_user_drafts ON entries(user_id, updated_at DESC) WHERE status = 'draft';
+CREATE INDEX IF NOT EXISTS idx_entries_deleted_at ON entries(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_share_links_entry_id ON share_links(entry_id);
+CREATE INDEX IF NOT EXISTS idx_trip_members_user_id ON trip_members(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_follows_followee_id ON follows(followee_id);
+CREATE INDEX IF NOT EXISTS idx_comments_entry_id ON comments(entry_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_favorites_entry_id ON favorites(entry_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_unique ON users(LOWER(email));
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
+CREATE INDEX IF NOT EXISTS idx_login_challenges_user_id ON login_challenges(user_id);
+CREATE INDEX IF NOT EXISTS idx_entries_public_feed ON entries(user_id, created_at DESC, id DESC) WHERE status = 'published' AND visibility = 'public' AND deleted_at IS NULL;
\ No newline at end of file
@@ -1,14 +1,5 @@
 import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query'
-import axios from 'axios'
-
-// Simple API instance
-const api = axios.create({
-  baseURL: 'http://localhost:8080',
-  timeout: 10000,
-  headers: {
-    'Content-Type': 'application/json',
-  },
-})
+import { api } from '../lib/api'
 
 // Simple types
 interface LoginData {
@@ -37,6 +28,7 @@ export const useLogin = () => {
       const userData = { 
         id: data.user_id,
         username: data.username,
+        token: data.token,
         loggedIn: true 
       }
       localStorage.setItem('user', JSON.stringify(userData))
@@ -30,6 +30,8 @@ export interface RegisterRequest {
 export interface LoginResponse {
   message: string
   user_id: string
+  username: string
+  token: string
 }
 
 export interface RegisterResponse {
@@ -52,7 +54,6 @@ export interface CreateEntryRequest {
   content: string
   location?: string
   photos?: string[]
-  user_id?: string
 }
 
 export interface CreateEntryResponse {
@@ -81,12 +82,12 @@ export const entriesApi = {
   },
   
   getById: async (id: string): Promise<JournalEntry> => {
-    const response = await api.get(`/entries/${id}`)
+    const response = await api.get(`/entry/${id}`)
     return response.data
   },
 
   getByUserId: async (userId: string): Promise<JournalEntry[]> => {
-    const response = await api.get(`/users/${userId}/entries`)
+    const response = await api.get(`/entries/${userId}`)
     return response.data
   },
 
@@ -102,6 +103,16 @@ export const entriesApi = {
   }
 }
 
+// Send the session token saved at login with every request
+api.interceptors.request.use((config) => {
+  const user = localStorage.getItem('user')
+  const token = user ? JSON.parse(user).token : null
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`
+  }
+  return config
+})
+
 // Add request/response interceptors for error handling
 api.interceptors.response.use(
   (response) => response,
@@ -4,7 +4,6 @@ import { zodResolver } from '@hookform/resolvers/zod'
 import { z } from 'zod'
 import { useMutation, useQueryClient } from '@tanstack/react-query'
 import { entriesApi } from '../lib/api'
-import { useAuth } from '../hooks/useAuth'
 import { useState } from 'react'
 import { PhotoCropper } from '../components/PhotoCropper'
 
@@ -22,7 +21,6 @@ export const Route = createLazyFileRoute('/entries/new')({
 
 function NewEntry() {
   const navigate = useNavigate()
-  const { data: user } = useAuth()
   const queryClient = useQueryClient()
   const [uploadedPhotos, setUploadedPhotos] = useState<string[]>([])
   const [isUploadingPhoto, setIsUploadingPhoto] = useState(false)
@@ -85,10 +83,9 @@ function NewEntry() {
 
   const onSubmit = async (data: EntryForm) => {
     try {
-      // Include user_id and photos in the request
+      // The author comes from the session token, only photos need adding
       const entryData = {
         ...data,
-        user_id: user?.id,
         photos: uploadedPhotos
       }
       console.log('Creating entry with data:', entryData) // Debug log